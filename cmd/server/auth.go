@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"payment-proxy/internal/auth"
+
+	"github.com/valyala/fasthttp"
+)
+
+// authKeyUserValue guarda no RequestCtx a chave que autenticou a requisição
+const authKeyUserValue = "authKey"
+
+// requiredScope define o escopo exigido por rota; rotas públicas retornam ok=false
+func requiredScope(path []byte) (auth.Scope, bool) {
+	switch string(path) {
+	case "/health", "/metrics":
+		return "", false
+	case "/purge-payments":
+		return auth.ScopeAdmin, true
+	}
+	if bytes.HasPrefix(path, deadLettersPath) {
+		return auth.ScopeAdmin, true
+	}
+	return auth.ScopeClient, true
+}
+
+// authMiddleware aceita X-API-Key ou a assinatura HMAC (X-Key-Id, X-Timestamp, X-Nonce, X-Signature)
+func authMiddleware(authenticator *auth.Authenticator, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		scope, protected := requiredScope(ctx.Path())
+		if !protected {
+			next(ctx)
+			return
+		}
+
+		h := &ctx.Request.Header
+		key, err := authenticator.Authenticate(auth.Request{
+			APIKey:    string(h.Peek("X-API-Key")),
+			KeyID:     string(h.Peek("X-Key-Id")),
+			Timestamp: string(h.Peek("X-Timestamp")),
+			Nonce:     string(h.Peek("X-Nonce")),
+			Signature: string(h.Peek("X-Signature")),
+			Method:    string(ctx.Method()),
+			URI:       string(ctx.RequestURI()),
+			Body:      ctx.PostBody(),
+		})
+		if err != nil {
+			ctx.Response.Header.Set("WWW-Authenticate", `ApiKey, HMAC-SHA256`)
+			writeProblem(ctx, problem{
+				Type:   "/problems/unauthorized",
+				Title:  "Unauthorized",
+				Status: fasthttp.StatusUnauthorized,
+				Detail: authErrorDetail(err),
+			})
+			return
+		}
+
+		if !key.Scope.Allows(scope) {
+			writeProblem(ctx, problem{
+				Type:   "/problems/forbidden",
+				Title:  "Forbidden",
+				Status: fasthttp.StatusForbidden,
+				Detail: "this key is not allowed to call " + string(ctx.Path()),
+			})
+			return
+		}
+
+		ctx.SetUserValue(authKeyUserValue, key.ID)
+		next(ctx)
+	}
+}
+
+// authErrorDetail não revela se a chave existe, só o tipo de problema
+func authErrorDetail(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrMissingCredentials):
+		return "missing credentials"
+	case errors.Is(err, auth.ErrStaleTimestamp):
+		return "request timestamp outside the allowed window"
+	case errors.Is(err, auth.ErrReplayedNonce):
+		return "nonce already used"
+	default:
+		return "invalid credentials"
+	}
+}
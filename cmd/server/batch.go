@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"payment-proxy/internal/idempotency"
+	"payment-proxy/internal/payments/entities"
+	"payment-proxy/internal/wire"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	maxBatchBodySize = 1 << 20 // 1 MB
+	maxBatchItems    = 5000
+
+	// pacotes de um lote esperando ack ao mesmo tempo, por worker: todos de uma vez estouram o
+	// buffer de recepção do worker e viram retransmissões
+	batchInFlight = 8
+)
+
+const (
+	batchAccepted    = "accepted"
+	batchDuplicate   = "duplicate"
+	batchRejected    = "rejected"
+	batchUnconfirmed = "unconfirmed" // worker não respondeu; o item pode ou não ter sido aceito
+)
+
+// batchItemResult é o resultado de um item de POST /payments/batch
+type batchItemResult struct {
+	Index         int          `json:"index"`
+	CorrelationID string       `json:"correlationId,omitempty"`
+	Status        string       `json:"status"`
+	Reason        string       `json:"reason,omitempty"`
+	Errors        []fieldError `json:"errors,omitempty"`
+}
+
+type batchResult struct {
+	Accepted    int               `json:"accepted"`
+	Duplicate   int               `json:"duplicate"`
+	Rejected    int               `json:"rejected"`
+	Unconfirmed int               `json:"unconfirmed"`
+	Items       []batchItemResult `json:"items"`
+}
+
+// batchResponse é a resposta do worker: um status por item, na mesma ordem, e o registro
+// original dos itens duplicados ou em conflito, pelo índice
+type batchResponse struct {
+	Statuses []idempotency.Status       `json:"statuses"`
+	Records  map[int]idempotency.Record `json:"records,omitempty"`
+}
+
+func handlePaymentsBatch(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		return
+	}
+
+	body := ctx.PostBody()
+	if len(body) > maxBatchBodySize {
+		writeProblem(ctx, problem{
+			Type:   problemTooLarge,
+			Title:  "Payload too large",
+			Status: fasthttp.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("request body must not exceed %d bytes", maxBatchBodySize),
+		})
+		return
+	}
+
+	var raws [][]byte
+	if bytes.HasPrefix(ctx.Request.Header.ContentType(), []byte("application/x-ndjson")) {
+		raws = splitNDJSON(body)
+	} else {
+		var items []jsoniter.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			writeProblem(ctx, problem{
+				Type:   problemMalformedJSON,
+				Title:  "Malformed JSON",
+				Status: fasthttp.StatusBadRequest,
+				Detail: "request body must be a JSON array or application/x-ndjson",
+			})
+			return
+		}
+		raws = make([][]byte, len(items))
+		for i, item := range items {
+			raws[i] = item
+		}
+	}
+
+	if len(raws) > maxBatchItems {
+		writeProblem(ctx, problem{
+			Type:   problemTooLarge,
+			Title:  "Too many items",
+			Status: fasthttp.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("a batch must not exceed %d items", maxBatchItems),
+		})
+		return
+	}
+
+	result := batchResult{Items: make([]batchItemResult, len(raws))}
+	valid := make([]int, 0, len(raws))
+	validPayments := make([]entities.Payment, 0, len(raws))
+
+	for i, raw := range raws {
+		result.Items[i].Index = i
+		payment, prob := validatePayment(raw)
+		if prob != nil {
+			result.Items[i].Status = batchRejected
+			result.Items[i].Reason = prob.Title
+			result.Items[i].Errors = prob.Errors
+			continue
+		}
+		result.Items[i].CorrelationID = payment.CorrelationID
+		valid = append(valid, i)
+		validPayments = append(validPayments, payment)
+	}
+
+	statuses := sendPaymentBatch(validPayments)
+	for j, i := range valid {
+		item := &result.Items[i]
+		switch statuses[j] {
+		case idempotency.StatusCreated:
+			item.Status = batchAccepted
+		case idempotency.StatusDuplicate:
+			item.Status = batchDuplicate
+		case idempotency.StatusConflict:
+			item.Status = batchRejected
+			item.Reason = "correlationId already used with a different payload"
+		case idempotency.StatusRejected:
+			item.Status = batchRejected
+			item.Reason = "payment queue full, retry later"
+		default:
+			item.Status = batchUnconfirmed
+			item.Reason = "worker did not confirm the payment"
+		}
+	}
+
+	for _, item := range result.Items {
+		switch item.Status {
+		case batchAccepted:
+			result.Accepted++
+		case batchDuplicate:
+			result.Duplicate++
+		case batchRejected:
+			result.Rejected++
+		default:
+			result.Unconfirmed++
+		}
+	}
+
+	response, _ := json.Marshal(result)
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(response)
+}
+
+// splitNDJSON separa um corpo application/x-ndjson em linhas, ignorando linhas vazias
+func splitNDJSON(body []byte) [][]byte {
+	var lines [][]byte
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// sendPaymentBatch separa os pagamentos por worker e empacota os de cada um em datagramas
+// "insert_batch" de até worker.maxPacketSize bytes, enviando aos workers em paralelo.
+// Devolve o status de cada pagamento na ordem recebida.
+func sendPaymentBatch(batch []entities.Payment) []idempotency.Status {
+	statuses := make([]idempotency.Status, len(batch))
+
+	byShard := make(map[*workerClient][]int)
+	for i, p := range batch {
+		c := workers.forKey(p.CorrelationID)
+		byShard[c] = append(byShard[c], i)
+	}
+
+	var wg sync.WaitGroup
+	for c, indexes := range byShard {
+		wg.Add(1)
+		go func(c *workerClient, indexes []int) {
+			defer wg.Done()
+			c.sendBatch(batch, indexes, statuses)
+		}(c, indexes)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// sendBatch envia ao worker os pagamentos batch[indexes] e grava o status de cada um em
+// statuses. Itens de um pacote sem resposta ficam com status vazio (continuam no outbox);
+// com o outbox cheio ficam como rejeitados. Os pacotes vão em paralelo, até batchInFlight por
+// vez, e todos esperam o ack até o mesmo prazo: o lote inteiro leva no máximo um
+// server.insertTimeout, qualquer que seja o número de pacotes.
+func (c *workerClient) sendBatch(batch []entities.Payment, indexes []int, statuses []idempotency.Status) {
+	caps := c.caps()
+	if !caps.batch {
+		c.sendEach(batch, indexes, statuses)
+		return
+	}
+
+	var (
+		wg       sync.WaitGroup
+		items    []wire.BatchItem
+		inFlight = make(chan struct{}, batchInFlight)
+		deadline = time.Now().Add(cfg.Server.InsertTimeout)
+	)
+	size := batchPacketOverhead
+	first := 0 // posição em indexes do primeiro item no pacote atual
+
+	flush := func(next int) {
+		if next == first {
+			return
+		}
+		wg.Add(1)
+		go func(items []wire.BatchItem, indexes []int) {
+			defer wg.Done()
+			// passado o prazo o pacote vai sem esperar vaga: fica no outbox, sem ack
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			select {
+			case inFlight <- struct{}{}:
+				defer func() { <-inFlight }()
+			case <-timer.C:
+			}
+			c.sendBatchPacket(items, indexes, statuses, deadline)
+		}(items, indexes[first:next])
+		// o pacote enviado fica com items
+		items = nil
+		size = batchPacketOverhead
+		first = next
+	}
+
+	for j, i := range indexes {
+		item := wire.BatchItem{Payment: batch[i]}
+		itemSize := c.batchItemSize(&item)
+		if len(items) > 0 && size+itemSize > caps.maxPacket {
+			flush(j)
+		}
+		items = append(items, item)
+		size += itemSize
+	}
+	flush(len(indexes))
+	wg.Wait()
+}
+
+// sendBatchPacket envia um "insert_batch" e grava em statuses o status de cada item de indexes
+// que o worker confirmar até deadline
+func (c *workerClient) sendBatchPacket(items []wire.BatchItem, indexes []int, statuses []idempotency.Status, deadline time.Time) {
+	call := c.open("insert_batch")
+	data := c.encode(&wire.Request{ID: call.id, Sender: c.sender, Action: "insert_batch", Items: items})
+	resp, err := call.exchange(data, time.Until(deadline))
+	call.close()
+	if errors.Is(err, errOutboxFull) {
+		for _, i := range indexes {
+			statuses[i] = idempotency.StatusRejected
+		}
+		return
+	}
+	if err != nil {
+		slog.Warn("batch not confirmed by worker", "worker", c.addr, "items", len(items), "error", err)
+		return
+	}
+	var br batchResponse
+	if err := json.Unmarshal(resp, &br); err != nil || len(br.Statuses) != len(items) {
+		udpErrors.With("insert_batch", "decode").Inc()
+		slog.Error("erro ao converter resposta", "error", err)
+		return
+	}
+	for j, i := range indexes {
+		statuses[i] = br.Statuses[j]
+	}
+}
+
+// sendEach é o sendBatch para workers sem "insert_batch": um insert por pagamento, em paralelo
+func (c *workerClient) sendEach(batch []entities.Payment, indexes []int, statuses []idempotency.Status) {
+	var wg sync.WaitGroup
+	for _, i := range indexes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := c.insert("", batch[i])
+			if errors.Is(err, errOutboxFull) {
+				statuses[i] = idempotency.StatusRejected
+			} else if err == nil {
+				statuses[i] = result.Status
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// batchPacketOverhead reserva espaço para tudo que não é item: cabeçalho, action, sender e o maior id possível
+const batchPacketOverhead = 128
+
+// batchItemSize é quanto o item ocupa no formato configurado (+1 da vírgula no JSON)
+func (c *workerClient) batchItemSize(item *wire.BatchItem) int {
+	if c.caps().binary {
+		return wire.BatchItemSize(item)
+	}
+	data, _ := json.Marshal(item)
+	return len(data) + 1
+}
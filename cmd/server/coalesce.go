@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"payment-proxy/internal/idempotency"
+	"payment-proxy/internal/payments/entities"
+	"payment-proxy/internal/wire"
+	"time"
+)
+
+var (
+	errCoalescerFull    = errors.New("insert coalescer queue full")
+	errCoalescerStopped = errors.New("insert coalescer stopped")
+	errCoalescerTimeout = errors.New("insert not sent to the worker in time")
+)
+
+// coalescer junta os inserts avulsos de POST /payments em mensagens "insert_batch"
+// para um worker (um coalescer por worker). Uma única goroutine monta os pacotes e envia quando o próximo item passaria de
+// worker.maxPacketSize ou quando o mais antigo já esperou server.coalesceLinger.
+// Cada pacote enviado espera a resposta numa goroutine própria: uma por pacote, não por pagamento.
+type coalescer struct {
+	client  *workerClient
+	queue   chan *pendingInsert
+	linger  time.Duration
+	stopped chan struct{} // fechado quando run para de aceitar inserts
+}
+
+// pendingInsert é um insert esperando entrar num pacote; done recebe exatamente um resultado
+type pendingInsert struct {
+	key     string
+	payment entities.Payment
+	done    chan insertOutcome
+}
+
+type insertOutcome struct {
+	result idempotency.Result
+	err    error
+}
+
+func newCoalescer(client *workerClient, capacity int, linger time.Duration) *coalescer {
+	return &coalescer{
+		client:  client,
+		queue:   make(chan *pendingInsert, capacity),
+		linger:  linger,
+		stopped: make(chan struct{}),
+	}
+}
+
+// submit enfileira o insert e espera a resposta do worker para ele. A espera é limitada a
+// server.coalesceLinger mais server.insertTimeout (com folga): um insert que ficou na fila depois
+// de run parar nunca recebe resposta.
+func (co *coalescer) submit(key string, payment entities.Payment) (idempotency.Result, error) {
+	select {
+	case <-co.stopped:
+		return idempotency.Result{}, errCoalescerStopped
+	default:
+	}
+	p := &pendingInsert{key: key, payment: payment, done: make(chan insertOutcome, 1)}
+	select {
+	case co.queue <- p:
+	default:
+		return idempotency.Result{}, errCoalescerFull
+	}
+
+	timer := time.NewTimer(co.linger + cfg.Server.InsertTimeout + time.Second)
+	defer timer.Stop()
+	select {
+	case out := <-p.done:
+		return out.result, out.err
+	case <-timer.C:
+		return idempotency.Result{}, errCoalescerTimeout
+	}
+}
+
+// Len é o número de inserts esperando entrar num pacote
+func (co *coalescer) Len() int {
+	return len(co.queue)
+}
+
+// run monta e envia os pacotes até ctx ser cancelado. No cancelamento para de aceitar inserts
+// e envia os que já estavam na fila; sem ack eles ficam no outbox e vão para o spool.
+func (co *coalescer) run(ctx context.Context) {
+	var (
+		pending []*pendingInsert
+		items   []wire.BatchItem
+		size    = batchPacketOverhead
+	)
+	timer := time.NewTimer(co.linger)
+	timer.Stop()
+
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		timer.Stop()
+		go co.send(pending, items)
+		pending, items = nil, nil
+		size = batchPacketOverhead
+	}
+	add := func(p *pendingInsert) {
+		item := wire.BatchItem{Key: p.key, Payment: p.payment}
+		itemSize := co.client.batchItemSize(&item)
+		if len(items) > 0 && size+itemSize > co.client.caps().maxPacket {
+			flush()
+		}
+		if len(pending) == 0 {
+			timer.Reset(co.linger)
+		}
+		pending = append(pending, p)
+		items = append(items, item)
+		size += itemSize
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			close(co.stopped)
+			// run é o único leitor da fila: com len > 0 a leitura não bloqueia
+			for len(co.queue) > 0 {
+				add(<-co.queue)
+			}
+			flush()
+			return
+		case <-timer.C:
+			flush()
+		case p := <-co.queue:
+			add(p)
+		}
+	}
+}
+
+// send envia um pacote e distribui a resposta entre os inserts que ele carrega
+func (co *coalescer) send(pending []*pendingInsert, items []wire.BatchItem) {
+	coalescedBatchSize.Observe(float64(len(items)))
+
+	call := co.client.open("insert_batch")
+	defer call.close()
+	data := co.client.encode(&wire.Request{ID: call.id, Sender: co.client.sender, Action: "insert_batch", Items: items})
+
+	resp, err := call.exchange(data, cfg.Server.InsertTimeout)
+	var br batchResponse
+	if err == nil {
+		if err = json.Unmarshal(resp, &br); err == nil && len(br.Statuses) != len(items) {
+			err = errors.New("batch reply does not match the request")
+		}
+		if err != nil {
+			udpErrors.With("insert_batch", "decode").Inc()
+		}
+	}
+
+	for i, p := range pending {
+		if err != nil {
+			p.done <- insertOutcome{err: err}
+			continue
+		}
+		p.done <- insertOutcome{result: idempotency.Result{Status: br.Statuses[i], Record: br.Records[i]}}
+	}
+}
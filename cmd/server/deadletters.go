@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"payment-proxy/internal/payments/entities"
+	"payment-proxy/internal/wire"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Rotas de admin sobre os pagamentos que os workers desistiram de processar:
+//
+//	GET    /admin/dead-letters?from=&to=&limit=  lista, do mais recente para o mais antigo
+//	GET    /admin/dead-letters/{id}              pagamento, motivo e últimas tentativas
+//	POST   /admin/dead-letters/{id}/replay       devolve à fila com as tentativas zeradas
+//	DELETE /admin/dead-letters/{id}              descarta
+var deadLettersPath = []byte("/admin/dead-letters")
+
+const (
+	// o worker devolve no máximo 100 itens por consulta
+	maxDeadLetterLimit     = 100
+	defaultDeadLetterLimit = 50
+)
+
+var (
+	errDeadLettersUnsupported = errors.New("worker does not support dead letters")
+	errReplayQueueFull        = errors.New("retry queue full")
+)
+
+// deadLetterPage é a resposta do worker para "dlq_list" e também a de GET /admin/dead-letters
+type deadLetterPage struct {
+	Total int                          `json:"total"`
+	Items []entities.DeadLetterSummary `json:"items"`
+}
+
+func handleDeadLetters(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("application/json")
+
+	rest := ctx.Path()[len(deadLettersPath):]
+	if len(rest) == 0 {
+		if !ctx.IsGet() {
+			ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+			return
+		}
+		handleDeadLetterList(ctx)
+		return
+	}
+	if rest[0] != '/' || len(rest) == 1 {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+
+	rest = rest[1:]
+	replay := false
+	if id, ok := bytes.CutSuffix(rest, []byte("/replay")); ok {
+		rest, replay = id, true
+	}
+	if len(rest) == 0 || bytes.IndexByte(rest, '/') >= 0 {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+	correlationID := string(rest)
+
+	var action string
+	switch {
+	case replay && ctx.IsPost():
+		action = "dlq_replay"
+	case !replay && ctx.IsGet():
+		action = "dlq_get"
+	case !replay && ctx.IsDelete():
+		action = "dlq_discard"
+	default:
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		return
+	}
+
+	dl, err := workers.forKey(correlationID).deadLetter(action, correlationID)
+	switch {
+	case errors.Is(err, errDeadLettersUnsupported):
+		ctx.SetStatusCode(fasthttp.StatusNotImplemented)
+		ctx.SetBody([]byte(`{"error":"worker does not support dead letters"}`))
+		return
+	case errors.Is(err, errReplayQueueFull):
+		ctx.Response.Header.Set("Retry-After", "1")
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.SetBody([]byte(`{"error":"worker retry queue is full"}`))
+		return
+	case err != nil:
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(`{"error":"failed to reach worker"}`))
+		return
+	case dl.Payment.CorrelationID == "":
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetBody([]byte(`{"error":"dead letter not found"}`))
+		return
+	}
+
+	switch action {
+	case "dlq_get":
+		response, _ := json.Marshal(dl)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetBody(response)
+	case "dlq_replay":
+		response, _ := json.Marshal(map[string]string{
+			"correlationId": correlationID,
+			"state":         string(entities.StateQueued),
+		})
+		ctx.SetStatusCode(fasthttp.StatusAccepted)
+		ctx.SetBody(response)
+	case "dlq_discard":
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	}
+}
+
+// handleDeadLetterList junta as listas de todos os workers. Para a próxima página, repita
+// a consulta com to igual ao deadAt do último item.
+func handleDeadLetterList(ctx *fasthttp.RequestCtx) {
+	queryArgs := ctx.QueryArgs()
+
+	var from, to *time.Time
+	if fromStr := queryArgs.Peek("from"); len(fromStr) > 0 {
+		t, err := time.ParseInLocation(time.RFC3339, string(fromStr), time.UTC)
+		if err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBody([]byte(`{"error":"invalid 'from' date"}`))
+			return
+		}
+		from = &t
+	}
+	if toStr := queryArgs.Peek("to"); len(toStr) > 0 {
+		t, err := time.ParseInLocation(time.RFC3339, string(toStr), time.UTC)
+		if err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBody([]byte(`{"error":"invalid 'to' date"}`))
+			return
+		}
+		to = &t
+	}
+	limit := defaultDeadLetterLimit
+	if limitStr := queryArgs.Peek("limit"); len(limitStr) > 0 {
+		n, err := strconv.Atoi(string(limitStr))
+		if err != nil || n < 1 || n > maxDeadLetterLimit {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBody([]byte(`{"error":"invalid 'limit', use 1 to 100"}`))
+			return
+		}
+		limit = n
+	}
+
+	page, err := listDeadLetters(from, to, limit)
+	if err != nil && !acceptPartial(ctx, err) {
+		return
+	}
+
+	response, _ := json.Marshal(page)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(response)
+}
+
+// listDeadLetters pede a lista a cada worker e mantém os limit mais recentes. Como getSummary,
+// com workers faltando retorna o que chegou e um *shardsError.
+func listDeadLetters(from, to *time.Time, limit int) (deadLetterPage, error) {
+	var (
+		mu   sync.Mutex
+		page = deadLetterPage{Items: []entities.DeadLetterSummary{}}
+	)
+	err := workers.fanOut(func(c *workerClient) error {
+		if !c.caps().dlq {
+			return nil
+		}
+		p, err := c.listDeadLetters(from, to)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		page.Total += p.Total
+		page.Items = append(page.Items, p.Items...)
+		mu.Unlock()
+		return nil
+	})
+
+	sort.Slice(page.Items, func(i, j int) bool { return page.Items[i].DeadAt.After(page.Items[j].DeadAt) })
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+	}
+	return page, err
+}
+
+func (c *workerClient) listDeadLetters(from, to *time.Time) (deadLetterPage, error) {
+	req := &wire.Request{Action: "dlq_list", From: from, To: to}
+
+	resp, err := c.roundTrip(req, cfg.Server.QueryTimeout)
+	if err != nil {
+		slog.Error("erro ao ler resposta", "worker", c.addr, "error", err)
+		return deadLetterPage{}, err
+	}
+
+	var page deadLetterPage
+	if err := json.Unmarshal(resp, &page); err != nil {
+		udpErrors.With("dlq_list", "decode").Inc()
+		slog.Error("erro ao converter resposta", "error", err)
+		return deadLetterPage{}, err
+	}
+	return page, nil
+}
+
+// deadLetter executa action ("dlq_get", "dlq_replay" ou "dlq_discard") e devolve o pagamento
+// afetado; correlationId vazio significa que ele não está no dead-letter do worker
+func (c *workerClient) deadLetter(action, correlationID string) (entities.DeadLetter, error) {
+	if !c.caps().dlq {
+		return entities.DeadLetter{}, errDeadLettersUnsupported
+	}
+	req := &wire.Request{Action: action, CorrelationID: correlationID}
+
+	resp, err := c.roundTrip(req, cfg.Server.QueryTimeout)
+	if err != nil {
+		slog.Error("erro ao ler resposta", "worker", c.addr, "error", err)
+		return entities.DeadLetter{}, err
+	}
+
+	var reply wire.ErrorReply
+	if json.Unmarshal(resp, &reply) == nil && reply.Error != "" {
+		if reply.Error == errReplayQueueFull.Error() {
+			return entities.DeadLetter{}, errReplayQueueFull
+		}
+		// worker sem handshake e anterior ao dead-letter
+		return entities.DeadLetter{}, errDeadLettersUnsupported
+	}
+	var dl entities.DeadLetter
+	if err := json.Unmarshal(resp, &dl); err != nil {
+		udpErrors.With(action, "decode").Inc()
+		slog.Error("erro ao converter resposta", "error", err)
+		return entities.DeadLetter{}, err
+	}
+	return dl, nil
+}
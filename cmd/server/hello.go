@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"payment-proxy/internal/wire"
+	"strconv"
+	"time"
+)
+
+// ações sem as quais a api não funciona; as demais só desligam o recurso que dependem delas
+var requiredActions = []string{"insert", "get", "status", "purge"}
+
+// handshake pergunta ao worker o que ele entende, repetindo até timeout porque o worker
+// pode ainda estar subindo. Sem resposta (ou com ctx cancelado) retorna nil; ver fallback.
+func (c *workerClient) handshake(ctx context.Context, timeout time.Duration) (*wire.Hello, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		attempt := min(500*time.Millisecond, time.Until(deadline))
+		if attempt <= 0 || ctx.Err() != nil {
+			return nil, nil
+		}
+		// sempre em JSON: um worker de outra versão pode não entender este frame binário
+		call := c.open("hello")
+		data, _ := json.Marshal(&wire.Request{ID: call.id, Sender: c.sender, Action: "hello"})
+		resp, err := call.exchange(data, attempt)
+		call.close()
+		if errors.Is(err, errReplyTimeout) {
+			continue
+		}
+		if err != nil {
+			// falha de envio (ex.: socket unix ainda não existe): espera o resto da tentativa
+			time.Sleep(attempt)
+			continue
+		}
+
+		var h wire.Hello
+		if err := json.Unmarshal(resp, &h); err != nil {
+			return nil, fmt.Errorf("invalid hello reply: %w", err)
+		}
+		return &h, nil
+	}
+}
+
+// fallback é usado enquanto o worker não responde o hello: sem saber o que ele entende, a api fica
+// com o que qualquer worker do protocolo 1 entende (JSON, inserts avulsos, sem wait e sem dead-letter)
+func (c *workerClient) fallback() {
+	c.negotiated.Store(&workerCaps{maxPacket: c.preferred.maxPacket})
+}
+
+// retryHello repete o hello em segundo plano depois do fallback até o worker responder (ele pode
+// estar restaurando o wal antes de ler o ipc) e então negocia normalmente
+func (c *workerClient) retryHello(ctx context.Context) {
+	for ctx.Err() == nil {
+		h, err := c.handshake(ctx, cfg.Server.HelloTimeout)
+		if err == nil && h == nil {
+			continue
+		}
+		if err == nil {
+			err = c.negotiate(h)
+		}
+		if err != nil {
+			// com requisições já em andamento não dá para parar a api: segue no fallback
+			slog.Error("worker answered hello but is incompatible, keeping fallback", "worker", c.addr, "error", err)
+		}
+		return
+	}
+}
+
+// negotiate ajusta o cliente ao que o worker anunciou. Retorna erro só quando não há como
+// conversar; recursos opcionais que faltam são desligados com um aviso.
+func (c *workerClient) negotiate(h *wire.Hello) error {
+	if !h.Compatible() {
+		return fmt.Errorf("worker speaks protocol %d (min %d), this api speaks %d (min %d)",
+			h.Protocol, h.MinProtocol, wire.ProtocolVersion, wire.MinProtocolVersion)
+	}
+	for _, action := range requiredActions {
+		if !h.SupportsAction(action) {
+			return fmt.Errorf("worker does not support action %q", action)
+		}
+	}
+	caps := c.preferred
+	caps.hello = h
+
+	if caps.binary && !h.SupportsBinary() {
+		slog.Warn("worker does not accept this binary wire version, falling back to json",
+			"worker", c.addr, "wireVersion", wire.Version, "workerVersions", h.WireVersions)
+		caps.binary = false
+	}
+	if h.MaxPacketSize > 0 && h.MaxPacketSize < caps.maxPacket {
+		slog.Warn("worker accepts smaller packets, lowering limit",
+			"worker", c.addr, "maxPacketSize", h.MaxPacketSize, "configured", caps.maxPacket)
+		caps.maxPacket = h.MaxPacketSize
+	}
+	if !h.SupportsAction("insert_batch") {
+		slog.Warn("worker does not support insert_batch, sending inserts one by one", "worker", c.addr)
+		caps.batch = false
+	}
+	if !h.SupportsFeature(wire.FeatureWait) {
+		slog.Warn("worker does not support wait, ignoring Prefer: wait", "worker", c.addr)
+		caps.wait = false
+	}
+	if !h.SupportsAction("dlq_list") {
+		slog.Warn("worker does not support dead-letter actions, leaving it out of /admin/dead-letters", "worker", c.addr)
+		caps.dlq = false
+	}
+	c.negotiated.Store(&caps)
+
+	workerInfo.With(c.addr, h.Build, strconv.Itoa(h.Protocol)).Set(1)
+	slog.Info("worker handshake", "worker", c.addr, "build", h.Build, "protocol", h.Protocol,
+		"transport", h.Transport, "maxPacketSize", h.MaxPacketSize)
+	return nil
+}
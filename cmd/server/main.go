@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
+	"payment-proxy/internal/auth"
+	"payment-proxy/internal/config"
+	"payment-proxy/internal/idempotency"
+	"payment-proxy/internal/ipcauth"
 	"payment-proxy/internal/payments/entities"
+	"payment-proxy/internal/transport"
+	"payment-proxy/internal/wire"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"net"
-
 	jsoniter "github.com/json-iterator/go"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/reuseport"
@@ -21,45 +29,90 @@ import (
 // JSON mais rápido
 var json = jsoniter.ConfigFastest
 
-// Conexão UDP persistente
-var udpConn *net.UDPConn
-var udpAddr *net.UDPAddr
+// Configuração efetiva, carregada uma vez no início do main
+var cfg *config.Config
+
+// Uma conexão persistente com cada worker, compartilhada por todas as requisições
+var workers *workerShards
+
+var paymentStatusPrefix = []byte("/payments/")
+
+// Intervalos aceitos em /payments-summary?interval=
+var summaryIntervals = map[string]time.Duration{
+	"1s": time.Second,
+	"1m": time.Minute,
+	"1h": time.Hour,
+}
+
+var errTooManyBuckets = errors.New("too many buckets")
 
-// Pool de buffers para reduzir GC
-var bufPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, 8192)
-	},
+// seriesResponse é a resposta do worker para um "get" com interval
+type seriesResponse struct {
+	Buckets []entities.SummaryBucket `json:"buckets"`
+	Error   string                   `json:"error,omitempty"`
 }
 
 func main() {
+	var err error
+	cfg, err = config.Load("server", os.Args[1:])
+	if err != nil {
+		log.Fatalf("Erro ao carregar configuração: %v", err)
+	}
+	if err := cfg.Validate(config.RoleServer); err != nil {
+		log.Fatalf("Configuração inválida:\n%v", err)
+	}
+	slog.Info("effective config: " + cfg.Dump())
+
 	// Graceful shutdown
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	initUDP()
+	initWorkers(ctx)
 
 	// Router
 	requestHandler := func(ctx *fasthttp.RequestCtx) {
 		switch string(ctx.Path()) {
 		case "/payments":
 			handlePayments(ctx)
+		case "/payments/batch":
+			handlePaymentsBatch(ctx)
 		case "/payments-summary":
 			handlePaymentsSummary(ctx)
 		case "/purge-payments":
 			handlePurgePayments(ctx)
 		case "/health":
 			handleHealth(ctx)
+		case "/metrics":
+			handleMetrics(ctx)
 		default:
+			if bytes.HasPrefix(ctx.Path(), deadLettersPath) {
+				handleDeadLetters(ctx)
+				return
+			}
+			if bytes.HasPrefix(ctx.Path(), paymentStatusPrefix) {
+				handlePaymentStatus(ctx)
+				return
+			}
 			ctx.SetStatusCode(fasthttp.StatusNotFound)
 		}
 	}
 
-	// Wrap com recover
-	handler := recoverMiddleware(requestHandler)
+	// Rate limit roda depois da autenticação para poder limitar por API key
+	if limiter := initRateLimit(ctx); limiter != nil {
+		requestHandler = rateLimitMiddleware(limiter, requestHandler)
+	}
+
+	// Autenticação é opcional: sem auth.keysFile a api continua aberta
+	if keysFile := cfg.Auth.KeysFile; keysFile != "" {
+		authenticator := initAuth(ctx, keysFile)
+		requestHandler = authMiddleware(authenticator, requestHandler)
+	}
+
+	// Wrap com recover e métricas
+	handler := metricsMiddleware(recoverMiddleware(requestHandler))
 
 	// Servidor
-	ln, err := reuseport.Listen("tcp4", ":9999")
+	ln, err := reuseport.Listen("tcp4", cfg.Server.ListenAddr)
 	if err != nil {
 		slog.Error("failed to listen", "error", err)
 		return
@@ -71,7 +124,7 @@ func main() {
 	}
 
 	go func() {
-		slog.Info("server started on :9999")
+		slog.Info("server started on " + cfg.Server.ListenAddr)
 		if err := server.Serve(ln); err != nil {
 			slog.Error("failed to start server", "error", err)
 		}
@@ -84,18 +137,144 @@ func main() {
 	if err := server.Shutdown(); err != nil {
 		slog.Error("error shutting down server", "error", err)
 	}
+	for _, c := range workers.clients {
+		if c.spool != nil {
+			c.spool.shutdown(c)
+		}
+	}
 }
 
-func initUDP() {
-	var err error
-	udpAddr, err = net.ResolveUDPAddr("udp", "172.25.0.12:9000")
-	if err != nil {
-		log.Fatalf("Erro ao resolver endereço UDP: %v", err)
+func initWorkers(ctx context.Context) {
+	// Com ipc.keysFile as mensagens são assinadas e o worker descarta as que não forem
+	var keys *ipcauth.Keyring
+	if cfg.IPC.KeysFile != "" {
+		var err error
+		if keys, err = ipcauth.Load(cfg.IPC.KeysFile, cfg.IPC.MaxSkew); err != nil {
+			log.Fatalf("Erro ao carregar chaves do ipc: %v", err)
+		}
+		go keys.Watch(ctx, cfg.IPC.ReloadInterval)
+	}
+
+	// o que chega do worker são respostas, de até wire.MaxReplySize, assinadas como as mensagens
+	maxMessageSize := max(cfg.Worker.MaxPacketSize, wire.MaxReplySize)
+	if keys != nil {
+		maxMessageSize += ipcauth.MaxOverhead
+	}
+
+	var clients []*workerClient
+	for _, addr := range cfg.Server.WorkerAddrs() {
+		conn, err := transport.Dial(cfg.Worker.Transport, addr, transport.Options{
+			MaxMessageSize: maxMessageSize,
+			PoolSize:       cfg.Server.PoolSize,
+		})
+		if err != nil {
+			log.Fatalf("Erro ao conectar ao worker %s (%s): %v", addr, cfg.Worker.Transport, err)
+		}
+		c := newWorkerClient(addr, conn, cfg.Server.RetransmitBuffer, cfg.Server.WireFormat == "binary")
+		c.keys = keys
+		if cfg.Spool.Dir != "" {
+			ps, err := openPaymentSpool(cfg.Spool, addr)
+			if err != nil {
+				log.Fatalf("Erro ao abrir spool do worker %s em %s: %v", addr, cfg.Spool.Dir, err)
+			}
+			go ps.s.Run(ctx)
+			spoolPending.WithFunc(func() float64 { return float64(ps.s.Pending()) }, addr)
+			spoolBytes.WithFunc(func() float64 { return float64(ps.s.Size()) }, addr)
+			c.spool = ps
+		}
+		go c.readLoop(ctx)
+		clients = append(clients, c)
+	}
+
+	// Handshake antes de qualquer envio: formato, tamanho dos pacotes e agrupamento dependem dele
+	if cfg.Server.HelloTimeout > 0 {
+		var wg sync.WaitGroup
+		for _, c := range clients {
+			wg.Add(1)
+			go func(c *workerClient) {
+				defer wg.Done()
+				h, err := c.handshake(ctx, cfg.Server.HelloTimeout)
+				if err == nil && h == nil {
+					slog.Warn("worker did not answer hello, falling back to json without insert_batch, wait or dead-letters until it does",
+						"worker", c.addr)
+					c.fallback()
+					go c.retryHello(ctx)
+					return
+				}
+				if err == nil {
+					err = c.negotiate(h)
+				}
+				if err != nil {
+					log.Fatalf("Worker %s incompatível: %v", c.addr, err)
+				}
+			}(c)
+		}
+		wg.Wait()
+	}
+
+	for _, c := range clients {
+		go c.outbox.run(ctx, c, cfg.Server.RetransmitInterval, cfg.Server.RetransmitMaxAge)
+		if cfg.Server.CoalesceLinger > 0 {
+			c.coalesce = newCoalescer(c, cfg.Server.CoalesceQueue, cfg.Server.CoalesceLinger)
+			go c.coalesce.run(ctx)
+		}
+	}
+	workers = newWorkerShards(clients)
+	slog.Info("workers: " + strings.Join(cfg.Server.WorkerAddrs(), ", "))
+
+	for _, c := range clients {
+		if c.spool != nil {
+			go c.spool.replay(ctx, c, cfg.Server.RetransmitInterval)
+		}
+	}
+	outboxDepth.WithFunc(func() float64 { return float64(workers.outboxLen()) })
+	if cfg.Server.CoalesceLinger > 0 {
+		coalescerDepth.WithFunc(func() float64 {
+			n := 0
+			for _, c := range workers.clients {
+				if c.coalesce != nil {
+					n += c.coalesce.Len()
+				}
+			}
+			return float64(n)
+		})
 	}
-	udpConn, err = net.DialUDP("udp", nil, udpAddr)
+}
+
+// initAuth carrega as chaves e as recarrega quando o arquivo muda ou ao receber SIGHUP
+func initAuth(ctx context.Context, keysFile string) *auth.Authenticator {
+	keystore, err := auth.LoadKeystore(keysFile)
 	if err != nil {
-		log.Fatalf("Erro ao conectar ao servidor UDP: %v", err)
+		log.Fatalf("Erro ao carregar chaves de autenticação: %v", err)
+	}
+
+	go keystore.Watch(ctx, cfg.Auth.ReloadInterval)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				signal.Stop(hup)
+				return
+			case <-hup:
+				if err := keystore.Reload(); err != nil {
+					slog.Error("failed to reload key file, keeping previous keys", "error", err)
+				} else {
+					slog.Info("key file reloaded", "path", keysFile)
+				}
+			}
+		}
+	}()
+
+	authenticator := auth.NewAuthenticator(keystore, cfg.Auth.MaxSkew)
+	if cfg.Redis.Addr != "" {
+		// nonces no Redis: uma requisição assinada aceita numa api não pode ser repetida na outra
+		authenticator.ShareNonces(sharedRedis().Client)
 	}
+	slog.Info("authentication enabled", "keysFile", keysFile, "sharedNonces", cfg.Redis.Addr != "")
+	return authenticator
 }
 
 func recoverMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
@@ -118,21 +297,119 @@ func handlePayments(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	var payment entities.Payment
-	if err := json.Unmarshal(ctx.PostBody(), &payment); err != nil {
-		ctx.SetContentType("application/json")
-		ctx.SetStatusCode(fasthttp.StatusBadRequest)
-		ctx.SetBody([]byte(`{"error":"invalid request"}`))
+	// Valida na borda: pagamentos inválidos nunca chegam à fila do worker
+	payment, prob := validatePayment(ctx.PostBody())
+	if prob != nil {
+		writeProblem(ctx, *prob)
 		return
 	}
 
-	// Envio assíncrono
-	go sendTestPayment(payment)
+	wait, prob := parseWait(ctx)
+	if prob != nil {
+		writeProblem(ctx, *prob)
+		return
+	}
+
+	// Idempotency-Key é opcional; sem ela a chave é o próprio correlationId
+	key := string(ctx.Request.Header.Peek("Idempotency-Key"))
+
+	// Prefer é só uma preferência: worker sem suporte a wait responde como um insert comum
+	if wait > 0 && workers.forKey(payment.CorrelationID).caps().wait {
+		handlePaymentAndWait(ctx, key, payment, wait)
+		return
+	}
 
-	ctx.SetStatusCode(fasthttp.StatusCreated)
+	result, err := sendPayment(key, payment)
+	if err != nil {
+		writeInsertError(ctx, payment, err)
+		return
+	}
+
+	writeInsertResult(ctx, result)
 	//slog.Info("payment sended in", "time", time.Since(start).Milliseconds())
 }
 
+// writeInsertError responde quando o worker não confirmou o insert. Sem ack a tempo o pagamento
+// continua no outbox (ou no spool) e será retransmitido, por isso 202; com o outbox cheio e
+// sem espaço no spool ele nem foi aceito.
+func writeInsertError(ctx *fasthttp.RequestCtx, payment entities.Payment, err error) {
+	if errors.Is(err, errSpooled) {
+		slog.Info("insert spooled", "correlationId", payment.CorrelationID)
+		ctx.SetStatusCode(fasthttp.StatusAccepted)
+		return
+	}
+	if errors.Is(err, errOutboxFull) || errors.Is(err, errCoalescerFull) ||
+		errors.Is(err, errCoalescerStopped) || errors.Is(err, errCoalescerTimeout) {
+		writeProblem(ctx, problem{
+			Type:   "about:blank",
+			Title:  "Service Unavailable",
+			Status: fasthttp.StatusServiceUnavailable,
+			Detail: "worker is not acknowledging payments, retry later",
+		})
+		return
+	}
+	slog.Warn("insert not confirmed by worker", "correlationId", payment.CorrelationID, "error", err)
+	ctx.SetStatusCode(fasthttp.StatusAccepted)
+}
+
+// writeInsertResult traduz o resultado da deduplicação no status HTTP
+func writeInsertResult(ctx *fasthttp.RequestCtx, result idempotency.Result) {
+	switch result.Status {
+	case idempotency.StatusCreated:
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+	case idempotency.StatusDuplicate:
+		writeIdempotencyResult(ctx, fasthttp.StatusOK, result)
+	case idempotency.StatusConflict:
+		writeIdempotencyResult(ctx, fasthttp.StatusConflict, result)
+	default:
+		writeProblem(ctx, problem{
+			Type:   "about:blank",
+			Title:  "Service Unavailable",
+			Status: fasthttp.StatusServiceUnavailable,
+			Detail: "payment queue full, retry later",
+		})
+	}
+}
+
+// writeIdempotencyResult devolve o resultado original do pagamento já visto
+func writeIdempotencyResult(ctx *fasthttp.RequestCtx, status int, result idempotency.Result) {
+	body, _ := json.Marshal(result)
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(status)
+	ctx.SetBody(body)
+}
+
+func handlePaymentStatus(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	ctx.SetContentType("application/json")
+
+	correlationID := string(ctx.Path()[len(paymentStatusPrefix):])
+	if correlationID == "" || bytes.IndexByte(ctx.Path()[len(paymentStatusPrefix):], '/') >= 0 {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetBody([]byte(`{"error":"payment not found"}`))
+		return
+	}
+
+	status, err := getPaymentStatus(correlationID)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(`{"error":"failed to get payment status"}`))
+		return
+	}
+	if status.CorrelationID == "" {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetBody([]byte(`{"error":"payment not found"}`))
+		return
+	}
+
+	response, _ := json.Marshal(status)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(response)
+}
+
 func handlePaymentsSummary(ctx *fasthttp.RequestCtx) {
 	start := time.Now()
 	ctx.SetContentType("application/json")
@@ -160,10 +437,20 @@ func handlePaymentsSummary(ctx *fasthttp.RequestCtx) {
 		}
 	}
 
+	if intervalStr := queryArgs.Peek("interval"); len(intervalStr) > 0 {
+		interval, ok := summaryIntervals[string(intervalStr)]
+		if !ok {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBody([]byte(`{"error":"invalid 'interval', use 1s, 1m or 1h"}`))
+			return
+		}
+		handlePaymentsSeries(ctx, from, to, interval)
+		slog.Info("handlePaymentsSummary", "time", time.Since(start).Milliseconds(), "interval", string(intervalStr))
+		return
+	}
+
 	summary, err := getSummary(from, to)
-	if err != nil {
-		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-		ctx.SetBody([]byte(`{"error":"failed to get summary"}`))
+	if err != nil && !acceptPartial(ctx, err) {
 		return
 	}
 
@@ -173,6 +460,46 @@ func handlePaymentsSummary(ctx *fasthttp.RequestCtx) {
 	ctx.SetBody(response)
 }
 
+// handlePaymentsSeries responde /payments-summary?interval=... com um array de buckets
+func handlePaymentsSeries(ctx *fasthttp.RequestCtx, from, to *time.Time, interval time.Duration) {
+	series, err := getSeries(from, to, interval)
+	if errors.Is(err, errTooManyBuckets) {
+		ctx.SetStatusCode(fasthttp.StatusUnprocessableEntity)
+		ctx.SetBody([]byte(`{"error":"too many buckets, narrow the range or use a larger interval"}`))
+		return
+	}
+	if err != nil && !acceptPartial(ctx, err) {
+		return
+	}
+
+	response, _ := json.Marshal(series)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(response)
+}
+
+// acceptPartial decide o que fazer quando parte dos workers não respondeu. Com
+// server.partialSummary a resposta segue com a soma dos que responderam e o cabeçalho
+// X-Partial-Result listando os que faltaram; sem ele (ou se nenhum respondeu) responde o erro.
+func acceptPartial(ctx *fasthttp.RequestCtx, err error) bool {
+	var se *shardsError
+	if !errors.As(err, &se) {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBody([]byte(`{"error":"failed to get summary"}`))
+		return false
+	}
+	if cfg.Server.PartialSummary && len(se.missing) < se.total {
+		ctx.Response.Header.Set("X-Partial-Result", "missing="+strings.Join(se.missing, ","))
+		return true
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"error":   "some workers did not answer in time",
+		"missing": se.missing,
+	})
+	ctx.SetStatusCode(fasthttp.StatusGatewayTimeout)
+	ctx.SetBody(body)
+	return false
+}
+
 func handlePurgePayments(ctx *fasthttp.RequestCtx) {
 	if !ctx.IsPost() {
 		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
@@ -194,36 +521,93 @@ func handleHealth(ctx *fasthttp.RequestCtx) {
 
 // ----------- Funções de backend otimizado -----------
 
-func sendTestPayment(payment entities.Payment) {
-	req := map[string]interface{}{
-		"action":  "insert",
-		"payment": payment,
+// sendPayment envia o pagamento ao worker e aguarda o resultado da deduplicação.
+// Com server.coalesceLinger > 0 ele segue junto com outros inserts num "insert_batch".
+func sendPayment(key string, payment entities.Payment) (idempotency.Result, error) {
+	c := workers.forKey(payment.CorrelationID)
+	// o coalescer manda "insert_batch": fica de fora enquanto o worker não confirmar que entende
+	if c.coalesce != nil && c.caps().batch {
+		return c.coalesce.submit(key, payment)
 	}
-	data, _ := json.Marshal(req)
-	udpConn.Write(data)
+	return c.insert(key, payment)
 }
 
-func getSummary(from, to *time.Time) (entities.AggregatedSummary, error) {
-	req := map[string]interface{}{
-		"action": "get",
-		"from":   from,
-		"to":     to,
+// insert envia um único pagamento numa mensagem "insert"
+func (c *workerClient) insert(key string, payment entities.Payment) (idempotency.Result, error) {
+	req := &wire.Request{Action: "insert", Key: key, Payment: payment}
+
+	resp, err := c.roundTrip(req, cfg.Server.InsertTimeout)
+	if err != nil {
+		return idempotency.Result{}, err
 	}
-	reqBytes, _ := json.Marshal(req)
-	udpConn.Write(reqBytes)
 
-	buf := bufPool.Get().([]byte)
-	defer bufPool.Put(buf)
+	var result idempotency.Result
+	if err := json.Unmarshal(resp, &result); err != nil {
+		udpErrors.With("insert", "decode").Inc()
+		slog.Error("erro ao converter resposta", "error", err)
+		return idempotency.Result{}, err
+	}
+	return result, nil
+}
+
+// getPaymentStatus consulta o ciclo de vida de um pagamento no worker responsável por ele
+func getPaymentStatus(correlationID string) (entities.PaymentStatus, error) {
+	req := &wire.Request{Action: "status", CorrelationID: correlationID}
 
-	udpConn.SetReadDeadline(time.Now().Add(1 * time.Second)) // timeout menor
-	n, _, err := udpConn.ReadFromUDP(buf)
+	resp, err := workers.forKey(correlationID).roundTrip(req, cfg.Server.QueryTimeout)
 	if err != nil {
 		slog.Error("erro ao ler resposta", "error", err)
+		return entities.PaymentStatus{}, err
+	}
+
+	var status entities.PaymentStatus
+	if err := json.Unmarshal(resp, &status); err != nil {
+		udpErrors.With("status", "decode").Inc()
+		slog.Error("erro ao converter resposta", "error", err)
+		return entities.PaymentStatus{}, err
+	}
+	return status, nil
+}
+
+// getSummary soma os resumos de todos os workers. Se algum não responder a tempo retorna
+// a soma dos que responderam junto de um *shardsError.
+func getSummary(from, to *time.Time) (entities.AggregatedSummary, error) {
+	var (
+		mu      sync.Mutex
+		summary entities.AggregatedSummary
+	)
+	err := workers.fanOut(func(c *workerClient) error {
+		s, err := c.getSummary(from, to)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		summary.Add(s)
+		mu.Unlock()
+		return nil
+	})
+	summary.RoundAmount()
+	return summary, err
+}
+
+func (c *workerClient) getSummary(from, to *time.Time) (entities.AggregatedSummary, error) {
+	req := &wire.Request{Action: "get", From: from, To: to}
+
+	resp, err := c.roundTrip(req, cfg.Server.QueryTimeout)
+	if err != nil {
+		slog.Error("erro ao ler resposta", "worker", c.addr, "error", err)
 		return entities.AggregatedSummary{}, err
 	}
 
+	// o worker responde no mesmo formato da requisição
 	var summary entities.AggregatedSummary
-	if err := json.Unmarshal(buf[:n], &summary); err != nil {
+	if wire.IsBinary(resp) {
+		_, err = wire.DecodeSummary(resp, &summary)
+	} else {
+		err = json.Unmarshal(resp, &summary)
+	}
+	if err != nil {
+		udpErrors.With("get", "decode").Inc()
 		slog.Error("erro ao converter resposta", "error", err)
 		return entities.AggregatedSummary{}, err
 	}
@@ -231,10 +615,76 @@ func getSummary(from, to *time.Time) (entities.AggregatedSummary, error) {
 	return summary, nil
 }
 
+// getSeries junta as séries de todos os workers somando os buckets de mesmo início.
+// Como getSummary, com workers faltando retorna o que chegou e um *shardsError.
+func getSeries(from, to *time.Time, interval time.Duration) ([]entities.SummaryBucket, error) {
+	var (
+		mu      sync.Mutex
+		byStart = make(map[time.Time]*entities.SummaryBucket)
+		tooMany bool
+	)
+	err := workers.fanOut(func(c *workerClient) error {
+		buckets, err := c.getSeries(from, to, interval)
+		mu.Lock()
+		defer mu.Unlock()
+		if errors.Is(err, errTooManyBuckets) {
+			tooMany = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, b := range buckets {
+			if merged, ok := byStart[b.Start]; ok {
+				merged.Add(b.AggregatedSummary)
+			} else {
+				b := b
+				byStart[b.Start] = &b
+			}
+		}
+		return nil
+	})
+	if tooMany {
+		return nil, errTooManyBuckets
+	}
+
+	series := make([]entities.SummaryBucket, 0, len(byStart))
+	for _, b := range byStart {
+		b.RoundAmount()
+		series = append(series, *b)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Start.Before(series[j].Start) })
+	return series, err
+}
+
+// getSeries pede ao worker a série de buckets
+func (c *workerClient) getSeries(from, to *time.Time, interval time.Duration) ([]entities.SummaryBucket, error) {
+	req := &wire.Request{Action: "get", From: from, To: to, Interval: interval.Milliseconds()}
+
+	resp, err := c.roundTrip(req, cfg.Server.QueryTimeout)
+	if err != nil {
+		slog.Error("erro ao ler resposta", "worker", c.addr, "error", err)
+		return nil, err
+	}
+
+	var series seriesResponse
+	if err := json.Unmarshal(resp, &series); err != nil {
+		udpErrors.With("get", "decode").Inc()
+		slog.Error("erro ao converter resposta", "error", err)
+		return nil, err
+	}
+	if series.Error == errTooManyBuckets.Error() {
+		return nil, errTooManyBuckets
+	}
+	if series.Error != "" {
+		return nil, errors.New(series.Error)
+	}
+	return series.Buckets, nil
+}
+
+// purge não espera resposta dos workers
 func purge() {
-	req := map[string]interface{}{
-		"action": "purge",
+	for _, c := range workers.clients {
+		c.send("purge", c.encode(&wire.Request{Action: "purge"}))
 	}
-	data, _ := json.Marshal(req)
-	udpConn.Write(data)
 }
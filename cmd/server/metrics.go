@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"payment-proxy/internal/metrics"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("payment_proxy_http_requests_total",
+		"HTTP requests handled by the api, by route, method and status code.", "route", "method", "code")
+	httpDuration = metrics.NewHistogramVec("payment_proxy_http_request_duration_seconds",
+		"HTTP request latency by route.", nil, "route")
+
+	udpSent = metrics.NewCounterVec("payment_proxy_udp_messages_sent_total",
+		"Datagrams sent to the worker, by action.", "action")
+	udpReceived = metrics.NewCounterVec("payment_proxy_udp_messages_received_total",
+		"Replies received from the worker, by action.", "action")
+	udpErrors = metrics.NewCounterVec("payment_proxy_udp_errors_total",
+		"Failed exchanges with the worker, by action and reason (write, timeout, decode).", "action", "reason")
+	udpUnmatched = metrics.NewCounterVec("payment_proxy_udp_unmatched_replies_total",
+		"Replies from the worker discarded because no request was waiting, by reason (late, unknown, malformed, overflow).", "reason")
+	ipcRejected = metrics.NewCounterVec("payment_proxy_ipc_rejected_replies_total",
+		"Replies from the worker rejected before decoding because their signature did not check, by reason.", "reason")
+	udpRetransmits = metrics.NewCounterVec("payment_proxy_udp_retransmits_total",
+		"Inserts sent again because the worker had not acknowledged them, by action.", "action")
+	udpExpired = metrics.NewCounterVec("payment_proxy_udp_unacked_expired_total",
+		"Inserts given up after server.retransmitMaxAge without an ack, by action.", "action")
+	outboxDepth = metrics.NewGaugeVec("payment_proxy_udp_unacked_messages",
+		"Inserts waiting for an ack from the worker.")
+	shardErrors = metrics.NewCounterVec("payment_proxy_shard_errors_total",
+		"Queries sent to every worker that one worker did not answer in time, by worker address.", "worker")
+	spoolWritten = metrics.NewCounter("payment_proxy_spool_written_payments_total",
+		"Payments written to the on-disk spool because the worker did not acknowledge them.")
+	spoolReplayed = metrics.NewCounter("payment_proxy_spool_replayed_payments_total",
+		"Spooled payments acknowledged by the worker after replay.")
+	spoolErrors = metrics.NewCounterVec("payment_proxy_spool_errors_total",
+		"Spool failures, by operation (write, read, decode).", "op")
+	spoolPending = metrics.NewGaugeVec("payment_proxy_spool_pending_payments",
+		"Payments in the spool waiting to be replayed, by worker address.", "worker")
+	spoolBytes = metrics.NewGaugeVec("payment_proxy_spool_bytes",
+		"Disk space used by the spool, by worker address.", "worker")
+	coalescerDepth = metrics.NewGaugeVec("payment_proxy_coalescer_queued_inserts",
+		"Inserts waiting to be packed into a message to the worker.")
+	coalescedBatchSize = metrics.NewHistogram("payment_proxy_coalesced_batch_size",
+		"Inserts packed into each message sent by the coalescer.", []float64{1, 2, 5, 10, 25, 50, 100, 200})
+	workerInfo = metrics.NewGaugeVec("payment_proxy_worker_info",
+		"Build and protocol version each worker reported in the hello handshake (always 1).", "worker", "build", "protocol")
+)
+
+// metricsMiddleware mede contagem e latência de cada requisição por rota
+func metricsMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		next(ctx)
+		route := routeName(ctx.Path())
+		httpDuration.With(route).Observe(time.Since(start).Seconds())
+		httpRequests.With(route, methodName(ctx), strconv.Itoa(ctx.Response.StatusCode())).Inc()
+	}
+}
+
+// methodName faz o mesmo para o método, que vem do cliente: só os que o roteador atende
+func methodName(ctx *fasthttp.RequestCtx) string {
+	switch {
+	case ctx.IsGet():
+		return fasthttp.MethodGet
+	case ctx.IsPost():
+		return fasthttp.MethodPost
+	case ctx.IsDelete():
+		return fasthttp.MethodDelete
+	}
+	return "other"
+}
+
+// routeName evita cardinalidade alta: ids no path viram um placeholder
+func routeName(path []byte) string {
+	switch string(path) {
+	case "/payments", "/payments/batch", "/payments-summary", "/purge-payments", "/health", "/metrics":
+		return string(path)
+	}
+	if bytes.HasPrefix(path, paymentStatusPrefix) {
+		return "/payments/{id}"
+	}
+	if bytes.HasPrefix(path, deadLettersPath) {
+		switch {
+		case len(path) == len(deadLettersPath):
+			return "/admin/dead-letters"
+		case bytes.HasSuffix(path, []byte("/replay")):
+			return "/admin/dead-letters/{id}/replay"
+		}
+		return "/admin/dead-letters/{id}"
+	}
+	return "other"
+}
+
+func handleMetrics(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType(metrics.ContentType)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	metrics.Default.WriteTo(ctx)
+}
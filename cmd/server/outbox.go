@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var errOutboxFull = errors.New("retransmit buffer full")
+
+// reliableActions são as mensagens que carregam pagamentos: ficam no outbox até o worker
+// responder, porque um datagrama perdido seria um pagamento perdido
+var reliableActions = map[string]bool{
+	"insert":       true,
+	"insert_batch": true,
+}
+
+type outboxEntry struct {
+	action    string
+	data      []byte
+	firstSent time.Time
+	lastSent  time.Time
+	attempts  int
+}
+
+// outbox guarda os inserts ainda sem ack, indexados pelo id (que serve de número de sequência).
+// A resposta do worker para aquele id é o ack; até lá a mensagem é reenviada periodicamente.
+type outbox struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[uint64]*outboxEntry
+}
+
+func newOutbox(capacity int) *outbox {
+	return &outbox{capacity: capacity, entries: make(map[uint64]*outboxEntry)}
+}
+
+// add registra a mensagem antes do primeiro envio. Com o buffer cheio a mensagem é recusada
+// em vez de descartar outra já aceita.
+func (o *outbox) add(id uint64, action string, data []byte) error {
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) >= o.capacity {
+		return errOutboxFull
+	}
+	o.entries[id] = &outboxEntry{action: action, data: data, firstSent: now, lastSent: now, attempts: 1}
+	return nil
+}
+
+// ack remove a mensagem id e retorna a action dela, se ainda estava pendente
+func (o *outbox) ack(id uint64) (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e, ok := o.entries[id]
+	if !ok {
+		return "", false
+	}
+	delete(o.entries, id)
+	return e.action, true
+}
+
+// drain remove e retorna todas as mensagens pendentes
+func (o *outbox) drain() []*outboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := make([]*outboxEntry, 0, len(o.entries))
+	for id, e := range o.entries {
+		out = append(out, e)
+		delete(o.entries, id)
+	}
+	return out
+}
+
+func (o *outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// run reenvia a cada interval as mensagens sem ack. As que passaram de maxAge vão para o
+// spool, se houver; sem spool são abandonadas.
+func (o *outbox) run(ctx context.Context, c *workerClient, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	type resend struct {
+		action string
+		data   []byte
+	}
+	var due, expired []resend
+
+	for {
+		select {
+		case <-ctx.Done():
+			// com spool, o desligamento grava o que sobrou (paymentSpool.shutdown)
+			if n := o.Len(); n > 0 && c.spool == nil {
+				slog.Warn("shutting down with unacknowledged inserts", "count", n)
+			}
+			return
+		case now := <-ticker.C:
+			due, expired = due[:0], expired[:0]
+			o.mu.Lock()
+			for id, e := range o.entries {
+				if now.Sub(e.firstSent) > maxAge {
+					delete(o.entries, id)
+					udpExpired.With(e.action).Inc()
+					if c.spool == nil {
+						slog.Error("insert never acknowledged by worker, giving up", "id", id, "action", e.action, "attempts", e.attempts)
+					}
+					expired = append(expired, resend{action: e.action, data: e.data})
+					continue
+				}
+				if now.Sub(e.lastSent) >= interval {
+					e.lastSent = now
+					e.attempts++
+					due = append(due, resend{action: e.action, data: e.data})
+				}
+			}
+			o.mu.Unlock()
+
+			// envia e grava fora do lock para não travar o leitor de acks
+			for _, r := range due {
+				if c.send(r.action, r.data) == nil {
+					udpRetransmits.With(r.action).Inc()
+				}
+			}
+			if c.spool != nil {
+				for _, r := range expired {
+					if err := c.spool.add(r.data); err != nil {
+						slog.Error("insert never acknowledged by worker and could not be spooled, giving up", "action", r.action, "error", err)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import "github.com/valyala/fasthttp"
+
+// problem segue a RFC 7807 (application/problem+json)
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError aponta qual campo do payload foi rejeitado e por quê
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+const (
+	problemValidation    = "/problems/validation-error"
+	problemMalformedJSON = "/problems/malformed-json"
+	problemTooLarge      = "/problems/payload-too-large"
+)
+
+func writeProblem(ctx *fasthttp.RequestCtx, p problem) {
+	if p.Instance == "" {
+		p.Instance = string(ctx.Path())
+	}
+	body, _ := json.Marshal(p)
+	ctx.SetContentType("application/problem+json")
+	ctx.SetStatusCode(p.Status)
+	ctx.SetBody(body)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"payment-proxy/internal/metrics"
+	"payment-proxy/internal/ratelimit"
+	"payment-proxy/internal/redis"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var rateLimited = metrics.NewCounter("payment_proxy_http_rate_limited_total",
+	"Requests rejected with 429 by the rate limiter.")
+
+// sharedRedis é o cliente do Redis da api, usado pelo rate limit e pelos nonces da autenticação
+var sharedRedis = sync.OnceValue(func() *redis.Client { return redis.NewClient(cfg.Redis.Addr) })
+
+// initRateLimit monta o limiter a partir de cfg.RateLimit.
+// Retorna nil quando o rate limit está desligado (rps zero).
+func initRateLimit(ctx context.Context) ratelimit.Limiter {
+	rps := cfg.RateLimit.RPS
+	if rps == 0 {
+		return nil
+	}
+	burst := cfg.RateLimit.Burst
+	if burst == 0 {
+		burst = int(rps)
+	}
+
+	var limiter interface {
+		ratelimit.Limiter
+		Run(ctx context.Context, interval time.Duration)
+	}
+	if cfg.RateLimit.Backend == "redis" {
+		// um único orçamento compartilhado entre api1 e api2
+		limiter = ratelimit.NewRedisLimiter(sharedRedis().Client, rps, burst)
+	} else {
+		limiter = ratelimit.NewLocalLimiter(rps, burst)
+	}
+	go limiter.Run(ctx, time.Minute)
+	return limiter
+}
+
+// rateLimitMiddleware limita por API key autenticada ou, sem autenticação, por IP do cliente
+func rateLimitMiddleware(limiter ratelimit.Limiter, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if _, protected := requiredScope(ctx.Path()); !protected {
+			next(ctx)
+			return
+		}
+
+		allowed, retryAfter := limiter.Allow(ctx, rateLimitKey(ctx))
+		if !allowed {
+			rateLimited.Inc()
+			secs := int((retryAfter + time.Second - 1) / time.Second)
+			if secs < 1 {
+				secs = 1
+			}
+			ctx.Response.Header.Set("Retry-After", strconv.Itoa(secs))
+			writeProblem(ctx, problem{
+				Type:   "/problems/rate-limited",
+				Title:  "Too Many Requests",
+				Status: fasthttp.StatusTooManyRequests,
+				Detail: "rate limit exceeded, retry after " + strconv.Itoa(secs) + "s",
+			})
+			return
+		}
+		next(ctx)
+	}
+}
+
+// rateLimitKey usa a chave autenticada ou o IP real repassado pelo nginx em X-Real-IP
+func rateLimitKey(ctx *fasthttp.RequestCtx) string {
+	if id, ok := ctx.UserValue(authKeyUserValue).(string); ok && id != "" {
+		return "key:" + id
+	}
+	if ip := ctx.Request.Header.Peek("X-Real-IP"); len(ip) > 0 {
+		return "ip:" + string(ip)
+	}
+	return "ip:" + ctx.RemoteIP().String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pontos de cada worker no anel: com poucos workers, mais pontos deixam a divisão mais uniforme
+const ringReplicas = 128
+
+// workerShards distribui os pagamentos entre os workers de server.workerAddr por hash
+// consistente do correlationId: o mesmo pagamento sempre vai para o mesmo worker, em qualquer
+// api, e incluir ou remover um worker só move a fração de pagamentos que cabia a ele.
+type workerShards struct {
+	clients []*workerClient
+	ring    []ringPoint // ordenado por hash
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+func newWorkerShards(clients []*workerClient) *workerShards {
+	s := &workerShards{clients: clients}
+	for i, c := range clients {
+		// o ponto depende só do endereço, não da posição na lista
+		for r := 0; r < ringReplicas; r++ {
+			s.ring = append(s.ring, ringPoint{hash: ringHash(c.addr + "#" + strconv.Itoa(r)), shard: i})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	return s
+}
+
+// forKey retorna o worker responsável pelo correlationId
+func (s *workerShards) forKey(correlationID string) *workerClient {
+	if len(s.clients) == 1 {
+		return s.clients[0]
+	}
+	h := ringHash(correlationID)
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.clients[s.ring[i].shard]
+}
+
+// ringHash é FNV-1a seguido do finalizador do splitmix64: precisa ser igual em todas as apis
+// (hash/maphash muda a cada processo) e espalhar bem chaves parecidas como "addr#1", "addr#2"
+func ringHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// shardsError lista os workers que não responderam a uma consulta feita a todos
+type shardsError struct {
+	missing []string
+	total   int
+}
+
+func (e *shardsError) Error() string {
+	return fmt.Sprintf("%d of %d workers did not answer: %s", len(e.missing), e.total, strings.Join(e.missing, ", "))
+}
+
+// fanOut chama fn para cada worker em paralelo e espera todos; cada fn respeita o próprio timeout.
+// Retorna *shardsError se algum falhou.
+func (s *workerShards) fanOut(fn func(c *workerClient) error) error {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		missing []string
+	)
+	for _, c := range s.clients {
+		wg.Add(1)
+		go func(c *workerClient) {
+			defer wg.Done()
+			if err := fn(c); err != nil {
+				shardErrors.With(c.addr).Inc()
+				mu.Lock()
+				missing = append(missing, c.addr)
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &shardsError{missing: missing, total: len(s.clients)}
+	}
+	return nil
+}
+
+// outboxLen soma os inserts sem ack de todos os workers
+func (s *workerShards) outboxLen() int {
+	n := 0
+	for _, c := range s.clients {
+		n += c.outbox.Len()
+	}
+	return n
+}
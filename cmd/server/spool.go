@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"payment-proxy/internal/config"
+	"payment-proxy/internal/idempotency"
+	"payment-proxy/internal/segment"
+	"payment-proxy/internal/spool"
+	"payment-proxy/internal/wire"
+	"strings"
+	"time"
+)
+
+// errSpooled indica que o insert não foi confirmado pelo worker mas está salvo no spool
+var errSpooled = errors.New("insert spooled until the worker is reachable")
+
+// paymentSpool guarda em disco os pagamentos que um worker não confirmou: os que não couberam
+// no outbox, os que passaram de server.retransmitMaxAge sem ack e os que ainda estavam no
+// outbox no desligamento. Cada registro é um wire.BatchItem em JSON, reenviado em ordem num
+// "insert_batch" quando o worker volta; a deduplicação do worker descarta o que já tinha chegado.
+// Cada worker tem o seu spool, num subdiretório de spool.dir: um worker fora do ar não segura o
+// reenvio dos outros.
+type paymentSpool struct {
+	s *spool.Spool
+}
+
+func openPaymentSpool(c config.SpoolConfig, addr string) (*paymentSpool, error) {
+	dir := filepath.Join(c.Dir, spoolDirName(addr))
+	s, rec, err := spool.Open(dir, spool.Options{
+		MaxBytes: int64(c.MaxBytes),
+		Options: segment.Options{
+			SegmentBytes:  int64(c.SegmentBytes),
+			Fsync:         segment.FsyncPolicy(c.Fsync),
+			FsyncInterval: c.FsyncInterval,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rec.Records > 0 || rec.TruncatedBytes > 0 {
+		slog.Warn("recovered payments from spool", "worker", addr, "dir", dir, "payments", rec.Records,
+			"segments", rec.Segments, "truncatedBytes", rec.TruncatedBytes)
+	}
+	return &paymentSpool{s: s}, nil
+}
+
+// spoolDirName transforma o endereço do worker (host:porta ou caminho de socket) num nome de diretório
+func spoolDirName(addr string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, addr)
+}
+
+// add grava os pagamentos de uma mensagem "insert" ou "insert_batch" já codificada
+func (ps *paymentSpool) add(data []byte) error {
+	items, err := decodeInsert(data)
+	if err != nil {
+		return err
+	}
+	records := make([][]byte, len(items))
+	for i := range items {
+		if records[i], err = json.Marshal(&items[i]); err != nil {
+			return err
+		}
+	}
+	if err := ps.s.Append(records...); err != nil {
+		spoolErrors.With("write").Inc()
+		return err
+	}
+	spoolWritten.Add(float64(len(items)))
+	return nil
+}
+
+// decodeInsert extrai os pagamentos de uma mensagem no formato em que foi enviada
+func decodeInsert(data []byte) ([]wire.BatchItem, error) {
+	var req wire.Request
+	var err error
+	if wire.IsBinary(data) {
+		err = wire.DecodeRequest(data, &req)
+	} else {
+		err = json.Unmarshal(data, &req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch req.Action {
+	case "insert":
+		return []wire.BatchItem{{Key: req.Key, Payment: req.Payment}}, nil
+	case "insert_batch":
+		return req.Items, nil
+	}
+	return nil, fmt.Errorf("cannot spool action %q", req.Action)
+}
+
+// replay reenvia o spool para c em ordem até ctx ser cancelado. Sem resposta do worker espera
+// interval e tenta de novo a partir do mesmo ponto.
+func (ps *paymentSpool) replay(ctx context.Context, c *workerClient, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if ps.s.Pending() > 0 && ps.replayOnce(c) {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replayOnce envia o próximo pacote do spool e consome o que o worker aceitou
+func (ps *paymentSpool) replayOnce(c *workerClient) bool {
+	records, err := ps.s.Peek(maxBatchItems, cfg.Worker.MaxPacketSize)
+	if err != nil {
+		spoolErrors.With("read").Inc()
+		slog.Error("failed to read spool", "worker", c.addr, "error", err)
+		return false
+	}
+
+	caps := c.caps()
+	var items []wire.BatchItem
+	size := batchPacketOverhead
+	for _, r := range records {
+		var item wire.BatchItem
+		if err := json.Unmarshal(r, &item); err != nil {
+			// registro ilegível mesmo com o checksum certo: não há o que reenviar
+			if len(items) == 0 {
+				spoolErrors.With("decode").Inc()
+				slog.Error("discarding unreadable spool record", "worker", c.addr, "error", err)
+				ps.s.Commit(1)
+				return true
+			}
+			break
+		}
+		itemSize := c.batchItemSize(&item)
+		if len(items) > 0 && (!caps.batch || size+itemSize > caps.maxPacket) {
+			break
+		}
+		items = append(items, item)
+		size += itemSize
+	}
+	if len(items) == 0 {
+		return false
+	}
+
+	// fora do outbox: se o worker não responder, o próprio replay tenta de novo
+	req := &wire.Request{Sender: c.sender, Action: "insert_batch", Items: items}
+	if !caps.batch {
+		// worker sem "insert_batch": um pagamento por vez
+		req = &wire.Request{Sender: c.sender, Action: "insert", Key: items[0].Key, Payment: items[0].Payment}
+	}
+	call := c.open(req.Action)
+	defer call.close()
+	req.ID = call.id
+	if err := c.send(req.Action, c.encode(req)); err != nil {
+		return false
+	}
+	resp, err := call.next(time.Now().Add(cfg.Server.InsertTimeout))
+	if err != nil {
+		return false
+	}
+	var br batchResponse
+	if req.Action == "insert" {
+		var result idempotency.Result
+		err = json.Unmarshal(resp, &result)
+		br.Statuses = []idempotency.Status{result.Status}
+	} else {
+		err = json.Unmarshal(resp, &br)
+	}
+	if err != nil || len(br.Statuses) != len(items) {
+		udpErrors.With(req.Action, "decode").Inc()
+		return false
+	}
+
+	// a fila do worker encheu no meio do pacote: consome até ali e tenta o resto depois
+	accepted := len(items)
+	for i, status := range br.Statuses {
+		if status == idempotency.StatusRejected {
+			accepted = i
+			break
+		}
+	}
+	if accepted > 0 {
+		if err := ps.s.Commit(accepted); err != nil {
+			spoolErrors.With("write").Inc()
+			slog.Error("failed to commit spool", "worker", c.addr, "error", err)
+			return false
+		}
+		spoolReplayed.Add(float64(accepted))
+	}
+	return accepted == len(items)
+}
+
+// shutdown passa para o spool o que ainda está no outbox de c e fecha o spool
+func (ps *paymentSpool) shutdown(c *workerClient) {
+	spooled := 0
+	for _, e := range c.outbox.drain() {
+		if err := ps.add(e.data); err != nil {
+			slog.Error("failed to spool unacknowledged insert on shutdown", "worker", c.addr, "action", e.action, "error", err)
+			continue
+		}
+		spooled++
+	}
+	if spooled > 0 {
+		slog.Info("unacknowledged inserts saved to spool", "worker", c.addr, "messages", spooled)
+	}
+	if err := ps.s.Close(); err != nil {
+		slog.Error("failed to close spool", "worker", c.addr, "error", err)
+	}
+}
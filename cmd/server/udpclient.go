@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"payment-proxy/internal/ipcauth"
+	"payment-proxy/internal/transport"
+	"payment-proxy/internal/wire"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var errReplyTimeout = errors.New("worker reply timeout")
+
+// replyEnvelope é o formato de toda resposta do worker: o id da requisição e o conteúdo
+type replyEnvelope struct {
+	ID    uint64              `json:"id"`
+	Reply jsoniter.RawMessage `json:"reply"`
+}
+
+// workerClient compartilha uma única conexão com o worker (ver internal/transport). Cada requisição leva um id
+// e uma goroutine leitora entrega cada resposta a quem está esperando aquele id;
+// respostas que chegam depois do timeout ou com id desconhecido são descartadas.
+//
+// Os ids são sequenciais por processo; junto com sender (aleatório a cada início) formam
+// o número de sequência que o worker usa para reconhecer retransmissões.
+type workerClient struct {
+	addr     string // endereço do worker em server.workerAddr
+	conn     transport.Conn
+	sender   string
+	lastID   atomic.Uint64
+	mu       sync.Mutex
+	pending  map[uint64]*workerCall
+	outbox   *outbox
+	spool    *paymentSpool    // um por worker; nil sem spool.dir
+	coalesce *coalescer       // nil com server.coalesceLinger=0
+	keys     *ipcauth.Keyring // assina requisições e confere respostas; nil sem ipc.keysFile
+
+	// preferred é o configurado; negotiated é trocado inteiro pelo handshake (ver hello.go), que
+	// pode terminar depois que as requisições já começaram. Sem server.helloTimeout são iguais.
+	preferred  workerCaps
+	negotiated atomic.Pointer[workerCaps]
+}
+
+// workerCaps é o que a api usa de um worker
+type workerCaps struct {
+	hello     *wire.Hello
+	binary    bool // envia requisições no formato binário de internal/wire em vez de JSON
+	maxPacket int  // tamanho máximo de um pacote para este worker
+	batch     bool // o worker entende "insert_batch"
+	wait      bool // o worker entende insert com Wait
+	dlq       bool // o worker entende as ações "dlq_*"
+}
+
+// workerCall é uma requisição esperando respostas. Um insert em modo wait recebe duas
+// (deduplicação e resultado final), por isso o canal tem folga.
+type workerCall struct {
+	id      uint64
+	action  string
+	client  *workerClient
+	replies chan []byte
+}
+
+func newWorkerClient(addr string, conn transport.Conn, outboxCapacity int, binary bool) *workerClient {
+	var b [8]byte
+	rand.Read(b[:])
+	c := &workerClient{
+		addr:    addr,
+		conn:    conn,
+		sender:  hex.EncodeToString(b[:]),
+		pending: make(map[uint64]*workerCall),
+		outbox:  newOutbox(outboxCapacity),
+
+		preferred: workerCaps{
+			binary:    binary,
+			maxPacket: cfg.Worker.MaxPacketSize,
+			batch:     true,
+			wait:      true,
+			dlq:       true,
+		},
+	}
+	caps := c.preferred
+	c.negotiated.Store(&caps)
+	return c
+}
+
+// caps é o que vale agora para o worker; não alterar o retorno
+func (c *workerClient) caps() *workerCaps {
+	return c.negotiated.Load()
+}
+
+// open reserva um id para action; quem chama precisa incluir call.id na mensagem e chamar close
+func (c *workerClient) open(action string) *workerCall {
+	call := &workerCall{
+		id:      c.lastID.Add(1),
+		action:  action,
+		client:  c,
+		replies: make(chan []byte, 2),
+	}
+	c.mu.Lock()
+	c.pending[call.id] = call
+	c.mu.Unlock()
+	return call
+}
+
+// roundTrip envia req com um id novo e espera uma única resposta
+func (c *workerClient) roundTrip(req *wire.Request, timeout time.Duration) ([]byte, error) {
+	call := c.open(req.Action)
+	defer call.close()
+
+	req.ID = call.id
+	req.Sender = c.sender
+	return call.exchange(c.encode(req), timeout)
+}
+
+// encode serializa req no formato configurado em server.wireFormat
+func (c *workerClient) encode(req *wire.Request) []byte {
+	if c.caps().binary {
+		return wire.AppendRequest(nil, req)
+	}
+	data, _ := json.Marshal(req)
+	return data
+}
+
+// send envia uma mensagem que não espera resposta (ex.: purge)
+func (c *workerClient) send(action string, data []byte) error {
+	// assinada no envio, não ao codificar: retransmissões do outbox e do spool levam o horário atual
+	if c.keys != nil {
+		data = c.keys.Seal(data)
+	}
+	if err := c.conn.Send(data); err != nil {
+		udpErrors.With(action, "write").Inc()
+		return err
+	}
+	udpSent.With(action).Inc()
+	return nil
+}
+
+// readLoop é o único leitor da conexão; roda até ctx ser cancelado
+func (c *workerClient) readLoop(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		c.conn.Close()
+	}()
+
+	buf := make([]byte, 65536) // maior datagrama UDP
+	for {
+		n, err := c.conn.Recv(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// ICMP port unreachable (worker fora do ar) aparece aqui; o chamador sofre timeout
+			slog.Debug("erro ao ler resposta do worker", "error", err)
+			continue
+		}
+
+		msg := buf[:n]
+		if c.keys != nil {
+			if msg, err = c.keys.Open(msg); err != nil {
+				ipcRejected.With(ipcauth.Reason(err)).Inc()
+				continue
+			}
+		}
+
+		// respostas binárias vão inteiras para quem chamou; JSON vem no envelope
+		var env replyEnvelope
+		if wire.IsBinary(msg) {
+			id, err := wire.ReplyID(msg)
+			if err != nil || id == 0 {
+				udpUnmatched.With("malformed").Inc()
+				continue
+			}
+			env = replyEnvelope{ID: id, Reply: msg}
+		} else if err := json.Unmarshal(msg, &env); err != nil || env.ID == 0 {
+			udpUnmatched.With("malformed").Inc()
+			continue
+		}
+
+		// qualquer resposta a um insert serve de ack, mesmo que ninguém mais esteja esperando
+		ackedAction, acked := c.outbox.ack(env.ID)
+
+		c.mu.Lock()
+		call, ok := c.pending[env.ID]
+		c.mu.Unlock()
+		if !ok && acked {
+			udpReceived.With(ackedAction).Inc()
+			continue
+		}
+		if !ok {
+			// ids são sequenciais: um id já emitido sem dono é resposta atrasada
+			if env.ID <= c.lastID.Load() {
+				udpUnmatched.With("late").Inc()
+			} else {
+				udpUnmatched.With("unknown").Inc()
+			}
+			continue
+		}
+
+		reply := make([]byte, len(env.Reply))
+		copy(reply, env.Reply)
+		select {
+		case call.replies <- reply:
+			udpReceived.With(call.action).Inc()
+		default:
+			udpUnmatched.With("overflow").Inc()
+		}
+	}
+}
+
+// send envia a mensagem da chamada. Inserts entram antes no outbox; se o primeiro envio
+// falhar, a retransmissão cuida dele e a chamada apenas espera o ack. Com o outbox cheio
+// vão direto para o spool, se houver.
+func (call *workerCall) send(data []byte) error {
+	if reliableActions[call.action] {
+		if err := call.client.outbox.add(call.id, call.action, data); err != nil {
+			if call.client.spool != nil && call.client.spool.add(data) == nil {
+				return errSpooled
+			}
+			return err
+		}
+		call.client.send(call.action, data)
+		return nil
+	}
+	return call.client.send(call.action, data)
+}
+
+// exchange envia data e espera uma única resposta
+func (call *workerCall) exchange(data []byte, timeout time.Duration) ([]byte, error) {
+	if err := call.send(data); err != nil {
+		return nil, err
+	}
+	resp, err := call.next(time.Now().Add(timeout))
+	if err != nil {
+		udpErrors.With(call.action, "timeout").Inc()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// next espera a próxima resposta até deadline
+func (call *workerCall) next(deadline time.Time) ([]byte, error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case reply := <-call.replies:
+		return reply, nil
+	case <-timer.C:
+		return nil, errReplyTimeout
+	}
+}
+
+// close libera o id; respostas que chegarem depois são contadas como atrasadas
+func (call *workerCall) close() {
+	call.client.mu.Lock()
+	delete(call.client.pending, call.id)
+	call.client.mu.Unlock()
+}
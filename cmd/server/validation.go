@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"payment-proxy/internal/payments/entities"
+	"sort"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	maxPaymentBodySize = 1024 // um pagamento válido tem bem menos que isso
+	maxAmountDecimals  = 2
+)
+
+// validatePayment valida o corpo de POST /payments e devolve o pagamento pronto
+// para o worker. Em caso de erro retorna o problem que deve ir para o cliente.
+func validatePayment(body []byte) (entities.Payment, *problem) {
+	if len(body) > maxPaymentBodySize {
+		return entities.Payment{}, &problem{
+			Type:   problemTooLarge,
+			Title:  "Payload too large",
+			Status: fasthttp.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("request body must not exceed %d bytes", maxPaymentBodySize),
+		}
+	}
+
+	var fields map[string]jsoniter.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return entities.Payment{}, &problem{
+			Type:   problemMalformedJSON,
+			Title:  "Malformed JSON",
+			Status: fasthttp.StatusBadRequest,
+			Detail: "request body must be a JSON object",
+		}
+	}
+
+	var payment entities.Payment
+	var errs []fieldError
+
+	var unknown []string
+	for name := range fields {
+		if name != "correlationId" && name != "amount" {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, fieldError{Field: name, Message: "unknown field"})
+	}
+
+	if raw, ok := fields["correlationId"]; !ok {
+		errs = append(errs, fieldError{Field: "correlationId", Message: "is required"})
+	} else if err := json.Unmarshal(raw, &payment.CorrelationID); err != nil {
+		errs = append(errs, fieldError{Field: "correlationId", Message: "must be a string"})
+	} else if !isUUID(payment.CorrelationID) {
+		errs = append(errs, fieldError{Field: "correlationId", Message: "must be a UUID"})
+	}
+
+	if raw, ok := fields["amount"]; !ok {
+		errs = append(errs, fieldError{Field: "amount", Message: "is required"})
+	} else if amount, msg := parseAmount(raw); msg != "" {
+		errs = append(errs, fieldError{Field: "amount", Message: msg})
+	} else {
+		payment.Amount = amount
+	}
+
+	if len(errs) > 0 {
+		return entities.Payment{}, &problem{
+			Type:   problemValidation,
+			Title:  "Invalid payment",
+			Status: fasthttp.StatusUnprocessableEntity,
+			Detail: "one or more fields are invalid",
+			Errors: errs,
+		}
+	}
+	return payment, nil
+}
+
+// parseAmount valida o número ainda como texto para conseguir contar as casas decimais
+func parseAmount(raw []byte) (float64, string) {
+	text := strings.TrimSpace(string(raw))
+	if text == "" || !(text[0] == '-' || (text[0] >= '0' && text[0] <= '9')) {
+		return 0, "must be a number"
+	}
+	amount, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return 0, "must be a number"
+	}
+	if amount <= 0 {
+		return 0, "must be greater than zero"
+	}
+	if decimalPlaces(text) > maxAmountDecimals {
+		return 0, fmt.Sprintf("must have at most %d decimal places", maxAmountDecimals)
+	}
+	return amount, ""
+}
+
+// decimalPlaces conta as casas decimais significativas de um número JSON, considerando expoente
+func decimalPlaces(text string) int {
+	mantissa, exp := text, 0
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		mantissa = text[:i]
+		exp, _ = strconv.Atoi(text[i+1:])
+	}
+	frac := ""
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		frac = strings.TrimRight(mantissa[i+1:], "0")
+	}
+	places := len(frac) - exp
+	if places < 0 {
+		return 0
+	}
+	return places
+}
+
+// isUUID aceita o formato canônico 8-4-4-4-12 em hexadecimal
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')) {
+				return false
+			}
+		}
+	}
+	return true
+}
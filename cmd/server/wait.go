@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"payment-proxy/internal/idempotency"
+	"payment-proxy/internal/payments/entities"
+	"payment-proxy/internal/wire"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// workerReply é qualquer datagrama que o worker manda em resposta a um insert:
+// o resultado da deduplicação ou, no modo wait, o resultado final do pagamento
+type workerReply struct {
+	idempotency.Result
+	Outcome *entities.PaymentStatus `json:"outcome"`
+}
+
+// parseWait lê "Prefer: wait=N" (segundos, RFC 7240) ou "?wait=5s".
+// Retorna zero quando o cliente não pediu o modo síncrono.
+// O valor é limitado a server.maxWait; o proxy na frente da api (nginx proxy_read_timeout)
+// precisa aceitar esse tempo.
+func parseWait(ctx *fasthttp.RequestCtx) (time.Duration, *problem) {
+	var wait time.Duration
+
+	if v := ctx.QueryArgs().Peek("wait"); len(v) > 0 {
+		d, err := time.ParseDuration(string(v))
+		if err != nil {
+			secs, errInt := strconv.Atoi(string(v))
+			if errInt != nil {
+				return 0, &problem{
+					Type:   problemValidation,
+					Title:  "Invalid wait",
+					Status: fasthttp.StatusBadRequest,
+					Detail: "wait must be a duration such as 5s",
+				}
+			}
+			d = time.Duration(secs) * time.Second
+		}
+		wait = d
+	} else if prefer := ctx.Request.Header.Peek("Prefer"); len(prefer) > 0 {
+		for _, pref := range bytes.Split(prefer, []byte(",")) {
+			pref = bytes.TrimSpace(pref)
+			if !bytes.HasPrefix(pref, []byte("wait=")) {
+				continue
+			}
+			// preferências inválidas são ignoradas, como manda a RFC 7240
+			if secs, err := strconv.Atoi(string(pref[len("wait="):])); err == nil {
+				wait = time.Duration(secs) * time.Second
+			}
+		}
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > cfg.Server.MaxWait {
+		wait = cfg.Server.MaxWait
+	}
+	return wait, nil
+}
+
+// handlePaymentAndWait segura a requisição até o worker informar o resultado final do pagamento
+func handlePaymentAndWait(ctx *fasthttp.RequestCtx, key string, payment entities.Payment, wait time.Duration) {
+	result, outcome, err := sendPaymentAndWait(key, payment, wait)
+	if err != nil {
+		writeInsertError(ctx, payment, err)
+		return
+	}
+
+	if result.Status != idempotency.StatusCreated && result.Status != idempotency.StatusDuplicate {
+		writeInsertResult(ctx, result)
+		return
+	}
+
+	ctx.Response.Header.Set("Preference-Applied", "wait="+strconv.Itoa(int(wait/time.Second)))
+
+	if outcome == nil {
+		// prazo expirou: o pagamento continua na fila
+		writeIdempotencyResult(ctx, fasthttp.StatusAccepted, result)
+		return
+	}
+
+	if outcome.State == entities.StateFailed {
+		writeProblem(ctx, problem{
+			Type:   "/problems/payment-failed",
+			Title:  "Payment failed",
+			Status: fasthttp.StatusBadGateway,
+			Detail: outcome.LastError,
+		})
+		return
+	}
+
+	status := fasthttp.StatusCreated
+	if result.Status == idempotency.StatusDuplicate {
+		status = fasthttp.StatusOK
+	}
+	body, _ := json.Marshal(outcome)
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(status)
+	ctx.SetBody(body)
+}
+
+// sendPaymentAndWait envia o insert pedindo o resultado final e recebe, pelo mesmo id,
+// a resposta da deduplicação e, se chegar antes de wait, o resultado final (em qualquer ordem)
+func sendPaymentAndWait(key string, payment entities.Payment, wait time.Duration) (idempotency.Result, *entities.PaymentStatus, error) {
+	c := workers.forKey(payment.CorrelationID)
+	call := c.open("insert")
+	defer call.close()
+
+	req := &wire.Request{
+		ID:      call.id,
+		Sender:  c.sender,
+		Action:  "insert",
+		Key:     key,
+		Payment: payment,
+		Wait:    wait.Milliseconds(),
+	}
+	if err := call.send(c.encode(req)); err != nil {
+		return idempotency.Result{}, nil, err
+	}
+
+	var result idempotency.Result
+	var outcome *entities.PaymentStatus
+	gotResult := false
+	resultDeadline := time.Now().Add(cfg.Server.InsertTimeout)
+	outcomeDeadline := time.Now().Add(wait)
+
+	for !gotResult || outcome == nil {
+		deadline := outcomeDeadline
+		if !gotResult && resultDeadline.Before(deadline) {
+			deadline = resultDeadline
+		}
+		resp, err := call.next(deadline)
+		if err != nil {
+			if !gotResult {
+				udpErrors.With("insert", "timeout").Inc()
+				return idempotency.Result{}, nil, err
+			}
+			// o resultado final não chegou dentro do prazo
+			return result, nil, nil
+		}
+
+		var reply workerReply
+		if err := json.Unmarshal(resp, &reply); err != nil {
+			udpErrors.With("insert", "decode").Inc()
+			continue
+		}
+		if reply.Outcome != nil {
+			outcome = reply.Outcome
+			continue
+		}
+		result = reply.Result
+		gotResult = true
+		if result.Status != idempotency.StatusCreated && result.Status != idempotency.StatusDuplicate {
+			return result, nil, nil
+		}
+	}
+	return result, outcome, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log"
+	"payment-proxy/internal/infra"
+	"payment-proxy/internal/payments/entities"
+	"payment-proxy/internal/transport"
+	"payment-proxy/internal/wire"
+	"time"
+)
+
+// maxDeadLetterPage limita quantos itens uma resposta de "dlq_list" traz
+const maxDeadLetterPage = 100
+
+// deadLetterPage é a resposta de "dlq_list": os mais recentes entre From e To e o total no período
+type deadLetterPage struct {
+	Total int                          `json:"total"`
+	Items []entities.DeadLetterSummary `json:"items"`
+}
+
+// handleDeadLetters responde as operações de admin sobre o dead-letter. "dlq_get",
+// "dlq_replay" e "dlq_discard" respondem o pagamento afetado; correlationId vazio
+// significa que ele não está no dead-letter.
+func handleDeadLetters(peer transport.Peer, req *wire.Request, queue *infra.PaymentsQueue) {
+	store := queue.DeadLetters()
+	switch req.Action {
+	case "dlq_list":
+		var page deadLetterPage
+		page.Total, page.Items = store.List(req.From, req.To, maxDeadLetterPage)
+		// mensagens de erro longas podem estourar o datagrama: devolve menos itens
+		for {
+			data, err := json.Marshal(replyEnvelope{ID: req.ID, Reply: page})
+			if err != nil {
+				log.Printf("Erro ao serializar resposta: %v", err)
+				return
+			}
+			if len(data) <= wire.MaxReplySize || len(page.Items) == 0 {
+				writeReply(peer, data)
+				return
+			}
+			page.Items = page.Items[:len(page.Items)/2]
+		}
+
+	case "dlq_get":
+		dl, _ := store.Get(req.CorrelationID)
+		replyJSON(peer, req.ID, dl)
+
+	case "dlq_discard":
+		dl, ok := queue.DiscardDeadLetter(req.CorrelationID)
+		if ok {
+			log.Printf("[INFO] dead-lettered payment discarded CorrelationID=%s", req.CorrelationID)
+		}
+		replyJSON(peer, req.ID, dl)
+
+	case "dlq_replay":
+		// pode esperar vaga no retryChan: não trava a leitura
+		go func(peer transport.Peer, id uint64, correlationID string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			dl, _, err := queue.Replay(ctx, correlationID)
+			if err != nil {
+				log.Printf("[WARN] replay of dead-lettered payment failed CorrelationID=%s err=%v", correlationID, err)
+				replyJSON(peer, id, wire.ErrorReply{Error: "retry queue full", Action: "dlq_replay"})
+				return
+			}
+			replyJSON(peer, id, dl)
+		}(peer, req.ID, req.CorrelationID)
+	}
+}
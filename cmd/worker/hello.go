@@ -0,0 +1,55 @@
+package main
+
+import (
+	"payment-proxy/internal/config"
+	"payment-proxy/internal/wire"
+	"runtime/debug"
+	"sort"
+)
+
+// newHello descreve este worker para o handshake com as apis
+func newHello(cfg *config.Config) *wire.Hello {
+	actions := make([]string, 0, len(knownActions))
+	for action := range knownActions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	return &wire.Hello{
+		Protocol:      wire.ProtocolVersion,
+		MinProtocol:   wire.MinProtocolVersion,
+		WireVersions:  []int{int(wire.Version)},
+		Build:         buildVersion(),
+		Actions:       actions,
+		Features:      []string{wire.FeatureWait, wire.FeatureSeries, wire.FeatureReplay},
+		MaxPacketSize: cfg.Worker.MaxPacketSize,
+		Transport:     cfg.Worker.Transport,
+	}
+}
+
+// buildVersion usa o commit gravado pelo go build (vcs.revision); "dev" fora de um checkout git
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"payment-proxy/internal/idempotency"
+	"payment-proxy/internal/infra"
+	"payment-proxy/internal/payments"
+	"payment-proxy/internal/payments/entities"
+	"time"
+)
+
+var errQueueFull = errors.New("payment queue full")
+
+// batchResponse traz o status de cada item do "insert_batch", na mesma ordem. Para duplicados
+// e conflitos leva também o registro original, que a api devolve nos inserts agrupados.
+type batchResponse struct {
+	Statuses []idempotency.Status       `json:"statuses"`
+	Records  map[int]idempotency.Record `json:"records,omitempty"`
+}
+
+// ingest concentra a entrada de pagamentos no worker: deduplicação, estado e fila
+type ingest struct {
+	dedupe      *idempotency.Store
+	tracker     *payments.StatusTracker
+	queue       *infra.PaymentsQueue // registra no wal antes de enfileirar
+	paymentChan chan<- infra.PendingPayment
+	dropIfFull  bool // se true, descarta pagamento quando channel cheio (evita bloquear UDP loop)
+}
+
+// accept deduplica e enfileira um item de "insert_batch". Não espera vaga na fila: 100ms por
+// item travariam a leitura do ipc pelo lote inteiro; o item recebe rejected e a api tenta de novo.
+func (in *ingest) accept(key string, p entities.Payment) idempotency.Result {
+	return in.admit(in.dedupe.Claim(key, p), p, false)
+}
+
+// admit enfileira um pagamento recém registrado no dedupe, esperando um pouco por vaga se wait;
+// se a fila estiver cheia, desfaz o registro para que o cliente possa tentar de novo
+func (in *ingest) admit(result idempotency.Result, p entities.Payment, wait bool) idempotency.Result {
+	if result.Status != idempotency.StatusCreated {
+		return result
+	}
+	in.tracker.Received(p.CorrelationID)
+	// a chave vai junto para que uma restauração a registre de novo no dedupe; sem
+	// Idempotency-Key ela é o próprio correlationId e não precisa ser guardada
+	pending := infra.PendingPayment{Payment: p}
+	if result.Record.Key != p.CorrelationID {
+		pending.Key = result.Record.Key
+	}
+	// sem o wal o pagamento ainda é aceito, só não sobrevive a uma queda
+	in.queue.LogAccepted(pending)
+	if in.enqueue(pending, wait) {
+		in.tracker.Queued(p.CorrelationID)
+		return result
+	}
+	in.queue.Discard(p.CorrelationID)
+	in.dedupe.Release(result.Record)
+	in.tracker.Failed(p.CorrelationID, 0, errQueueFull)
+	result.Status = idempotency.StatusRejected
+	return result
+}
+
+// enqueue tenta colocar o pagamento no channel sem travar o loop UDP por muito tempo; sem wait
+// não espera. Retorna false se o pagamento foi descartado.
+func (in *ingest) enqueue(p infra.PendingPayment, wait bool) bool {
+	select {
+	case in.paymentChan <- p:
+		return true
+	default:
+		// canal cheio
+		if in.dropIfFull || !wait {
+			// opcional: contabilizar dropped
+			log.Println("payment channel full: dropping payment")
+			udpDropped.With("queue_full").Inc()
+			return false
+		}
+		// bloqueia (com timeout) para evitar perder mensagens
+		select {
+		case in.paymentChan <- p:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			log.Println("payment channel still full after wait: dropping")
+			udpDropped.With("queue_full").Inc()
+			return false
+		}
+	}
+}
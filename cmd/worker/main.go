@@ -2,14 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
-	"net"
 	"os"
 	"os/signal"
+	"payment-proxy/internal/config"
+	"payment-proxy/internal/idempotency"
 	"payment-proxy/internal/infra"
+	"payment-proxy/internal/ipcauth"
 	"payment-proxy/internal/payment_processor"
 	"payment-proxy/internal/payments"
 	"payment-proxy/internal/payments/entities"
+	"payment-proxy/internal/segment"
+	"payment-proxy/internal/transport"
+	"payment-proxy/internal/wal"
+	"payment-proxy/internal/wire"
 	"sync"
 	"syscall"
 	"time"
@@ -19,31 +26,63 @@ import (
 
 var json = jsoniter.ConfigFastest
 
-// Configs ajustáveis
-const (
-	udpPort          = ":9000"
-	udpReadTimeout   = 1 * time.Second
-	paymentChanBuf   = 50000
-	workerMultiplier = 2                      // workers = NumCPU * workerMultiplier
-	batchSize        = 500                    // flush batch quando atingir
-	batchMaxWait     = 200 * time.Millisecond // flush batch por timeout
-	maxUDPPacketSize = 8192
-	dropIfQueueFull  = false // se true, descarta pagamento quando channel cheio (evita bloquear UDP loop)
-)
-
 func main() {
+	// Demais configs ajustáveis ficam em internal/config (defaults, arquivo, env e flags)
+	cfg, err := config.Load("worker", os.Args[1:])
+	if err != nil {
+		log.Fatalf("Erro ao carregar configuração: %v", err)
+	}
+	if err := cfg.Validate(config.RoleWorker); err != nil {
+		log.Fatalf("Configuração inválida:\n%v", err)
+	}
+	log.Printf("Configuração efetiva: %s", cfg.Dump())
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	gatewayManager := payment_processor.NewGatewayManager()
+	gatewayManager := payment_processor.NewGatewayManager(cfg.Gateways.DefaultURL, cfg.Gateways.FallbackURL, cfg.Gateways.Timeout, cfg.Breaker, cfg.Limiter)
+
+	dedupe := idempotency.NewStore(cfg.Worker.IdempotencyWindow)
+	go dedupe.Run(ctx, 30*time.Second)
+
+	// respostas dos inserts, para responder retransmissões do servidor sem reprocessar
+	replay := newReplayCache(cfg.Worker.ReplayWindow)
+	go replay.Run(ctx, 30*time.Second)
 
 	repo := payments.NewInMemoryPaymentDB()
 	service := payments.NewPaymentService(repo)
-	redisQueue := infra.NewPaymentQueue(ctx, service, gatewayManager)
+	tracker := payments.NewStatusTracker(cfg.Worker.StatusTTL)
+	go tracker.Run(ctx, 30*time.Second)
+	// as chamadas simultâneas são limitadas por gateway (limiter); sem queue.workers sobe
+	// goroutines suficientes para os dois gateways chegarem a limiter.max
+	if cfg.Queue.Workers == 0 {
+		cfg.Queue.Workers = 2 * cfg.Limiter.Max
+	}
+	redisQueue := infra.NewPaymentQueue(ctx, service, gatewayManager, tracker, cfg.Queue)
+
+	// Com wal.dir cada pagamento aceito fica em disco até terminar; o que sobrou de uma
+	// parada (ou queda) volta para a fila antes de o worker aceitar mensagens novas
+	var paymentLog *wal.Log
+	var walEntries []wal.Entry
+	if cfg.WAL.Dir != "" {
+		var rec wal.Recovery
+		paymentLog, walEntries, rec, err = wal.Open(cfg.WAL.Dir, segment.Options{
+			SegmentBytes:  int64(cfg.WAL.SegmentBytes),
+			Fsync:         segment.FsyncPolicy(cfg.WAL.Fsync),
+			FsyncInterval: cfg.WAL.FsyncInterval,
+		})
+		if err != nil {
+			log.Fatalf("Erro ao abrir wal em %s: %v", cfg.WAL.Dir, err)
+		}
+		log.Printf("wal em %s: %d segmentos, %d pagamentos pendentes, %d bytes truncados",
+			cfg.WAL.Dir, rec.Segments, rec.Entries, rec.TruncatedBytes)
+		go paymentLog.Run(ctx)
+		redisQueue.SetWAL(paymentLog)
+	}
 
 	// start external consumers (seu código)
 	go func() {
-		ticker := time.NewTicker(5 * time.Second)
+		ticker := time.NewTicker(cfg.Worker.HealthCheckInterval)
 		defer ticker.Stop()
 		for {
 			select {
@@ -55,28 +94,59 @@ func main() {
 		}
 	}()
 
-	// start redis queue consumer (this will push to paymentChan)
-	paymentChan := make(chan entities.Payment, paymentChanBuf)
-	go redisQueue.StartConsumer(ctx, paymentChan)
+	paymentChan := make(chan infra.PendingPayment, cfg.Worker.PaymentChanBuffer)
+	in := &ingest{dedupe: dedupe, tracker: tracker, queue: redisQueue, paymentChan: paymentChan, dropIfFull: cfg.Worker.DropIfQueueFull}
 
-	// UDP server
-	addr, err := net.ResolveUDPAddr("udp", udpPort)
-	if err != nil {
-		log.Fatalf("Erro ao resolver endereço UDP: %v", err)
+	// Com ipc.keysFile só mensagens assinadas por uma api com a mesma chave são aceitas
+	maxMessageSize := cfg.Worker.MaxPacketSize
+	if cfg.IPC.KeysFile != "" {
+		if ipcKeys, err = ipcauth.Load(cfg.IPC.KeysFile, cfg.IPC.MaxSkew); err != nil {
+			log.Fatalf("Erro ao carregar chaves do ipc: %v", err)
+		}
+		go ipcKeys.Watch(ctx, cfg.IPC.ReloadInterval)
+		maxMessageSize += ipcauth.MaxOverhead
+		log.Printf("Mensagens do ipc autenticadas com as chaves de %s", cfg.IPC.KeysFile)
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
+	// Servidor ipc: udp por padrão, ou o transporte em worker.transport
+	conn, err := transport.Listen(cfg.Worker.Transport, cfg.Worker.ListenAddr, transport.Options{
+		MaxMessageSize: maxMessageSize,
+	})
 	if err != nil {
-		log.Fatalf("Erro ao iniciar servidor UDP: %v", err)
+		log.Fatalf("Erro ao iniciar servidor %s: %v", cfg.Worker.Transport, err)
 	}
 	defer conn.Close()
 
-	log.Println("Servidor UDP escutando na porta", udpPort)
+	log.Printf("Servidor %s escutando em %s", cfg.Worker.Transport, cfg.Worker.ListenAddr)
 
-	// Pool de buffers para leitura UDP
+	// Servidores em modo "Prefer: wait" recebem o resultado final do pagamento
+	waiters := newOutcomeWaiters()
+	go waiters.Run(ctx, time.Second)
+	redisQueue.SetOutcomeHandler(func(status entities.PaymentStatus) {
+		sendOutcome(waiters.Take(status.CorrelationID), status)
+	})
+
+	// start redis queue consumer (this will push to paymentChan). A fila não para com o sinal:
+	// depois que a leitura do ipc para, Stop esvazia o que sobrou (ver stopQueue)
+	redisQueue.StartConsumer(context.WithoutCancel(ctx), paymentChan)
+
+	// o que ficou na fila na última parada volta antes de aceitar pagamentos novos
+	restoreWAL(ctx, walEntries, redisQueue, dedupe, tracker)
+	walEntries = nil
+	unrestored := restorePending(ctx, cfg.Worker.PendingFile, redisQueue, dedupe, tracker)
+
+	queueDepth.WithFunc(func() float64 { return float64(len(paymentChan)) }, "payment")
+	queueDepth.WithFunc(func() float64 { return float64(redisQueue.RetryDepth()) }, "retry")
+	deadLetterCount.WithFunc(func() float64 { return float64(redisQueue.DeadLetters().Len()) })
+	go serveMetrics(ctx, cfg.Worker.MetricsAddr)
+
+	hello := newHello(cfg)
+	log.Printf("Protocolo %d (mínimo %d), build %s", hello.Protocol, hello.MinProtocol, hello.Build)
+
+	// Pool de buffers para leitura das mensagens
 	var bufferPool = sync.Pool{
 		New: func() interface{} {
-			return make([]byte, maxUDPPacketSize)
+			return make([]byte, maxMessageSize)
 		},
 	}
 
@@ -84,87 +154,194 @@ func main() {
 		// Permite sair do loop quando ctx.Done() for fechado
 		select {
 		case <-ctx.Done():
-			log.Println("context canceled, shutting down UDP loop")
+			log.Println("context canceled, shutting down read loop")
+			stopQueue(redisQueue, cfg.Worker.ShutdownTimeout, cfg.Worker.PendingFile, unrestored, paymentLog)
 			return
 		default:
 		}
 
 		buf := bufferPool.Get().([]byte)
-		_ = conn.SetReadDeadline(time.Now().Add(udpReadTimeout))
-		n, remoteAddr, err := conn.ReadFromUDP(buf)
+		_ = conn.SetReadDeadline(time.Now().Add(cfg.Worker.ReadTimeout))
+		n, peer, err := conn.ReadFrom(buf)
 		if err != nil {
 			// se for timeout, apenas continue para checar ctx.Done()
-			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
 				bufferPool.Put(buf)
 				continue
 			}
-			log.Printf("Erro ao ler pacote UDP: %v", err)
+			log.Printf("Erro ao ler mensagem: %v", err)
 			bufferPool.Put(buf)
 			continue
 		}
 
-		// Decodifica mensagem (não alocar além do necessário)
-		var req PaymentRequest
-		if err := json.Unmarshal(buf[:n], &req); err != nil {
-			log.Printf("Erro ao parsear JSON: %v", err)
+		// A assinatura é conferida antes de qualquer decodificação
+		msg := buf[:n]
+		if ipcKeys != nil {
+			if msg, err = ipcKeys.Open(msg); err != nil {
+				ipcRejected.With(ipcauth.Reason(err)).Inc()
+				bufferPool.Put(buf)
+				continue
+			}
+		}
+
+		// Decodifica mensagem (não alocar além do necessário); JSON continua aceito
+		// para servidores ainda não atualizados
+		var req wire.Request
+		binaryReq := wire.IsBinary(msg)
+		if binaryReq {
+			err = wire.DecodeRequest(msg, &req)
+		} else {
+			err = json.Unmarshal(msg, &req)
+		}
+		if err != nil {
+			log.Printf("Erro ao decodificar mensagem: %v", err)
+			udpDropped.With("decode_error").Inc()
 			bufferPool.Put(buf)
 			continue
 		}
 		bufferPool.Put(buf)
+		udpReceived.With(actionLabel(req.Action)).Inc()
+
+		// retransmissão de um insert já tratado: reenvia o mesmo ack
+		if req.Sender != "" && (req.Action == "insert" || req.Action == "insert_batch") {
+			if reply, ok := replay.Get(req.Sender, req.ID); ok {
+				udpReplayed.Inc()
+				writeReply(peer, reply)
+				continue
+			}
+		}
 
 		switch req.Action {
 		case "insert":
-			// Não bloquear o loop UDP: tenta enviar no channel sem bloquear
-			select {
-			case paymentChan <- req.Payment:
-				// ok
-			default:
-				// canal cheio
-				if dropIfQueueFull {
-					// opcional: contabilizar dropped
-					log.Println("payment channel full: dropping payment")
-				} else {
-					// bloqueia (com timeout) para evitar perder mensagens
-					select {
-					case paymentChan <- req.Payment:
-					case <-time.After(100 * time.Millisecond):
-						log.Println("payment channel still full after wait: dropping")
+			// Deduplica antes de enfileirar: um retry do cliente (em qualquer api) não pode cobrar duas vezes
+			result := dedupe.Claim(req.Key, req.Payment)
+			waiting := req.Wait > 0 && (result.Status == idempotency.StatusCreated || result.Status == idempotency.StatusDuplicate)
+			if waiting {
+				// registra antes de enfileirar para não perder um resultado muito rápido
+				waiters.Add(result.Record.CorrelationID, peer, req.ID, time.Now().Add(time.Duration(req.Wait)*time.Millisecond))
+			}
+			result = in.admit(result, req.Payment, true)
+			if reply := replyJSON(peer, req.ID, result); req.Sender != "" {
+				replay.Remember(req.Sender, req.ID, reply)
+			}
+			if waiting && result.Status == idempotency.StatusDuplicate {
+				// duplicado de um pagamento que já terminou: responde o resultado na hora
+				if status, ok := tracker.Get(result.Record.CorrelationID); ok &&
+					(status.State == entities.StateProcessed || status.State == entities.StateFailed) {
+					sendOutcome(waiters.Take(status.CorrelationID), status)
+				}
+			}
+
+		case "insert_batch":
+			// Vários pagamentos numa mensagem (lotes e inserts agrupados pela api);
+			// a resposta traz um status por item, na mesma ordem
+			resp := batchResponse{Statuses: make([]idempotency.Status, len(req.Items))}
+			for i, item := range req.Items {
+				result := in.accept(item.Key, item.Payment)
+				resp.Statuses[i] = result.Status
+				if result.Status == idempotency.StatusDuplicate || result.Status == idempotency.StatusConflict {
+					if resp.Records == nil {
+						resp.Records = make(map[int]idempotency.Record)
 					}
+					resp.Records[i] = result.Record
 				}
 			}
+			if reply := replyJSON(peer, req.ID, resp); req.Sender != "" {
+				replay.Remember(req.Sender, req.ID, reply)
+			}
 
 		case "get":
-			// Responder em goroutine para não travar leitura UDP
-			go func(remote *net.UDPAddr, from, to *time.Time) {
+			// Responder em goroutine para não travar a leitura
+			if req.Interval > 0 {
+				go sendSeries(repo, peer, req.ID, req.From, req.To, time.Duration(req.Interval)*time.Millisecond)
+				continue
+			}
+			go func(peer transport.Peer, id uint64, from, to *time.Time, binaryReply bool) {
 				results, err := repo.GetByDateRange(context.Background(), from, to)
 				if err != nil {
 					log.Printf("Erro ao consultar repo: %v", err)
 					return
 				}
-				respBytes, err := json.Marshal(results)
-				if err != nil {
-					log.Printf("Erro ao serializar resposta: %v", err)
+				// responde no formato da requisição: servidores antigos só entendem JSON
+				if binaryReply {
+					writeReply(peer, wire.AppendSummary(nil, id, &results))
 					return
 				}
-				if _, err := conn.WriteToUDP(respBytes, remote); err != nil {
-					log.Printf("Erro ao enviar resposta UDP: %v", err)
-				}
-			}(remoteAddr, req.From, req.To)
+				replyJSON(peer, id, results)
+			}(peer, req.ID, req.From, req.To, binaryReq)
+
+		case "status":
+			// Consulta leve em memória; status vazio (sem correlationId) significa não encontrado
+			status, _ := tracker.Get(req.CorrelationID)
+			replyJSON(peer, req.ID, status)
 
 		case "purge":
 			// Operação leve — pode executar synchronously
 			repo.Purge(context.Background())
+			dedupe.Purge()
+			replay.Purge()
+			tracker.Purge()
+			waiters.Purge()
 			redisQueue.ClearQueue()
+			redisQueue.DeadLetters().Purge()
+		case "hello":
+			// Handshake: a api confere versão, ações e limites antes de usar o worker
+			replyJSON(peer, req.ID, hello)
+
+		case "dlq_list", "dlq_get", "dlq_replay", "dlq_discard":
+			// Pagamentos que a fila desistiu de processar (ver deadletters.go)
+			handleDeadLetters(peer, &req, redisQueue)
+
 		default:
+			udpDropped.With("unknown_action").Inc()
 			log.Printf("Ação desconhecida: %s", req.Action)
+			// quem espera uma resposta fica sabendo na hora, sem esperar o timeout
+			if req.ID != 0 {
+				replyJSON(peer, req.ID, wire.ErrorReply{Error: "unknown action", Action: req.Action})
+			}
 		}
 	}
 }
 
-// PaymentRequest - estrutura para comunicação UDP (mantive do seu original)
-type PaymentRequest struct {
-	Action  string           `json:"action"`  // "insert" ou "get"
-	Payment entities.Payment `json:"payment"` // usado apenas se Action == "insert"
-	From    *time.Time       `json:"from"`    // usado apenas se Action == "get"
-	To      *time.Time       `json:"to"`      // usado apenas se Action == "get"
+// errTooManyBuckets é devolvido quando a série não cabe num datagrama
+const errTooManyBuckets = "too many buckets"
+
+// seriesResponse é a resposta de um "get" com interval
+type seriesResponse struct {
+	Buckets []entities.SummaryBucket `json:"buckets"`
+	Error   string                   `json:"error,omitempty"`
+}
+
+// sendSeries responde um "get" com interval. Se a série não couber num datagrama,
+// responde com erro para o servidor pedir um intervalo maior ou um período menor.
+func sendSeries(repo *payments.InMemoryPaymentDB, peer transport.Peer, id uint64, from, to *time.Time, interval time.Duration) {
+	var resp seriesResponse
+	buckets, err := repo.GetSeries(context.Background(), from, to, interval)
+	if err != nil {
+		log.Printf("Erro ao consultar repo: %v", err)
+		resp.Error = err.Error()
+	} else {
+		resp.Buckets = buckets
+	}
+
+	respBytes, err := json.Marshal(replyEnvelope{ID: id, Reply: resp})
+	if err != nil {
+		log.Printf("Erro ao serializar resposta: %v", err)
+		return
+	}
+	if len(respBytes) > wire.MaxReplySize {
+		respBytes, _ = json.Marshal(replyEnvelope{ID: id, Reply: seriesResponse{Error: errTooManyBuckets}})
+	}
+	writeReply(peer, respBytes)
+}
+
+// outcomeMessage é enviado ao servidor que está esperando o resultado final de um pagamento
+type outcomeMessage struct {
+	Outcome entities.PaymentStatus `json:"outcome"`
+}
+
+func sendOutcome(waiting []waiter, status entities.PaymentStatus) {
+	for _, w := range waiting {
+		replyJSON(w.peer, w.requestID, outcomeMessage{Outcome: status})
+	}
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"payment-proxy/internal/ipcauth"
+	"payment-proxy/internal/metrics"
+	"payment-proxy/internal/transport"
+	"time"
+)
+
+var (
+	udpReceived = metrics.NewCounterVec("payment_proxy_worker_udp_messages_received_total",
+		"Datagrams received by the worker, by action.", "action")
+	udpSent = metrics.NewCounter("payment_proxy_worker_udp_messages_sent_total",
+		"Datagrams sent by the worker (replies and outcomes).")
+	udpReplayed = metrics.NewCounter("payment_proxy_worker_udp_retransmissions_total",
+		"Retransmitted inserts answered from the replay cache instead of being processed again.")
+	udpDropped = metrics.NewCounterVec("payment_proxy_worker_udp_messages_dropped_total",
+		"Datagrams or payments dropped by the worker, by reason.", "reason")
+	ipcRejected = metrics.NewCounterVec("payment_proxy_worker_ipc_rejected_total",
+		"Messages rejected before decoding because their signature did not check, by reason.", "reason")
+	queueDepth = metrics.NewGaugeVec("payment_proxy_worker_queue_depth",
+		"Payments waiting in the worker channels.", "queue")
+	deadLetterCount = metrics.NewGaugeVec("payment_proxy_worker_dead_letters",
+		"Payments in the dead-letter store waiting to be replayed or discarded.")
+)
+
+// knownActions limita a cardinalidade da label action
+var knownActions = map[string]bool{
+	"insert": true, "insert_batch": true, "get": true, "status": true, "purge": true, "hello": true,
+	"dlq_list": true, "dlq_get": true, "dlq_replay": true, "dlq_discard": true,
+}
+
+func actionLabel(action string) string {
+	if knownActions[action] {
+		return action
+	}
+	return "unknown"
+}
+
+// ipcKeys assina as respostas e confere as requisições; nil sem ipc.keysFile
+var ipcKeys *ipcauth.Keyring
+
+// writeReply envia uma resposta a peer contabilizando sucesso e falha
+func writeReply(peer transport.Peer, data []byte) {
+	if ipcKeys != nil {
+		data = ipcKeys.Seal(data)
+	}
+	if err := peer.Send(data); err != nil {
+		udpDropped.With("write_error").Inc()
+		log.Printf("Erro ao enviar resposta para %s: %v", peer, err)
+		return
+	}
+	udpSent.Inc()
+}
+
+// replyEnvelope devolve o id da requisição junto da resposta, para que o servidor
+// entregue a resposta a quem a pediu mesmo compartilhando uma única conexão
+type replyEnvelope struct {
+	ID    uint64      `json:"id"`
+	Reply interface{} `json:"reply"`
+}
+
+// replyJSON serializa v e envia como resposta à requisição id; retorna a mensagem enviada
+func replyJSON(peer transport.Peer, id uint64, v interface{}) []byte {
+	data, err := json.Marshal(replyEnvelope{ID: id, Reply: v})
+	if err != nil {
+		log.Printf("Erro ao serializar resposta: %v", err)
+		return nil
+	}
+	writeReply(peer, data)
+	return data
+}
+
+// serveMetrics expõe /metrics em HTTP até ctx ser cancelado
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	log.Println("Métricas em", addr+"/metrics")
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Erro no servidor de métricas: %v", err)
+	}
+}
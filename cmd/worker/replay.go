@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type replayEntry struct {
+	reply   []byte
+	expires time.Time
+}
+
+// replayCache guarda a resposta de cada insert por (sender, id) durante a janela de replay.
+// Uma retransmissão do servidor recebe exatamente a mesma resposta, sem passar de novo
+// pelo dedupe: o cliente vê 201 mesmo que o primeiro ack tenha se perdido.
+type replayCache struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]replayEntry
+}
+
+func newReplayCache(window time.Duration) *replayCache {
+	return &replayCache{window: window, entries: make(map[string]replayEntry)}
+}
+
+func replayKey(sender string, id uint64) string {
+	return sender + ":" + strconv.FormatUint(id, 10)
+}
+
+// Get retorna a resposta já enviada para a mensagem, se ela for uma retransmissão
+func (c *replayCache) Get(sender string, id uint64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[replayKey(sender, id)]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.reply, true
+}
+
+func (c *replayCache) Remember(sender string, id uint64, reply []byte) {
+	c.mu.Lock()
+	c.entries[replayKey(sender, id)] = replayEntry{reply: reply, expires: time.Now().Add(c.window)}
+	c.mu.Unlock()
+}
+
+// Run remove periodicamente as respostas fora da janela
+func (c *replayCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			for k, e := range c.entries {
+				if now.After(e.expires) {
+					delete(c.entries, k)
+				}
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *replayCache) Purge() {
+	c.mu.Lock()
+	c.entries = make(map[string]replayEntry)
+	c.mu.Unlock()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"payment-proxy/internal/idempotency"
+	"payment-proxy/internal/infra"
+	"payment-proxy/internal/payments"
+	"payment-proxy/internal/wal"
+	"time"
+)
+
+// stopQueue é chamado depois que a leitura do ipc parou: processa o que ainda está na fila por
+// até timeout e salva o resto (mais unrestored, o que restorePending não chegou a devolver à
+// fila) em pendingFile para a próxima execução. Com wal o que ficou na fila já está nele e só
+// unrestored vai para o arquivo.
+func stopQueue(q *infra.PaymentsQueue, timeout time.Duration, pendingFile string, unrestored []infra.PendingPayment, w *wal.Log) {
+	drained, pending := q.Stop(timeout)
+	log.Printf("Fila encerrada: %d pagamentos processados durante o desligamento, %d pendentes", drained, len(pending)+len(unrestored))
+	if w != nil {
+		if len(pending) > 0 {
+			log.Printf("%d pagamentos pendentes mantidos no wal", len(pending))
+		}
+		if err := w.Close(); err != nil {
+			log.Printf("[ERROR] falha ao fechar o wal: %v", err)
+		}
+		pending = nil
+	}
+	pending = append(pending, unrestored...)
+	if len(pending) == 0 {
+		return
+	}
+	if pendingFile == "" {
+		log.Printf("[WARN] worker.pendingFile vazio: %d pagamentos pendentes descartados", len(pending))
+		return
+	}
+	if err := infra.SavePending(pendingFile, pending); err != nil {
+		log.Printf("[ERROR] falha ao salvar %d pagamentos pendentes em %s: %v", len(pending), pendingFile, err)
+		return
+	}
+	log.Printf("%d pagamentos pendentes salvos em %s", len(pending), pendingFile)
+}
+
+// restoreWAL devolve à fila os pagamentos que o wal tinha sem checkpoint e ao dead-letter os que
+// estavam nele. Os que não voltarem (ctx cancelado no meio) continuam no wal para a próxima execução.
+func restoreWAL(ctx context.Context, entries []wal.Entry, q *infra.PaymentsQueue, dedupe *idempotency.Store, tracker *payments.StatusTracker) {
+	pending, deadLetters := q.DecodeWAL(entries)
+	if len(deadLetters) > 0 {
+		// continuam failed e registrados no dedupe: uma retransmissão não os processa de novo
+		for _, dl := range deadLetters {
+			dedupe.Claim(dl.Key, dl.Payment)
+			tracker.Received(dl.Payment.CorrelationID)
+			tracker.Failed(dl.Payment.CorrelationID, dl.Attempts, errors.New(dl.LastError))
+		}
+		q.RestoreDeadLetters(deadLetters)
+		log.Printf("%d pagamentos restaurados do wal para o dead-letter", len(deadLetters))
+	}
+	if len(pending) == 0 {
+		return
+	}
+	restored, _ := requeue(ctx, pending, q, dedupe, tracker)
+	log.Printf("%d de %d pagamentos pendentes restaurados do wal", restored, len(pending))
+}
+
+// restorePending devolve à fila os pagamentos salvos por stopQueue e apaga o arquivo.
+// Se ctx for cancelado no meio, retorna os que não voltaram para a fila.
+func restorePending(ctx context.Context, pendingFile string, q *infra.PaymentsQueue, dedupe *idempotency.Store, tracker *payments.StatusTracker) []infra.PendingPayment {
+	if pendingFile == "" {
+		return nil
+	}
+	pending, err := infra.LoadPending(pendingFile)
+	if err != nil {
+		// o que foi lido até o erro ainda é recuperado; o arquivo fica para inspeção
+		log.Printf("[ERROR] falha ao ler pagamentos pendentes: %v", err)
+	}
+	restored, rest := requeue(ctx, pending, q, dedupe, tracker)
+	if len(pending) > 0 {
+		log.Printf("%d pagamentos pendentes restaurados de %s", restored, pendingFile)
+	}
+	if err == nil && len(rest) == 0 {
+		if err := os.Remove(pendingFile); err != nil && !os.IsNotExist(err) {
+			log.Printf("[ERROR] falha ao apagar %s: %v", pendingFile, err)
+		}
+	}
+	return rest
+}
+
+// requeue devolve pagamentos à fila. Cada um volta a ser registrado no dedupe com a sua chave de
+// idempotência, para que uma retransmissão da api não o processe de novo; os já registrados são ignorados.
+func requeue(ctx context.Context, pending []infra.PendingPayment, q *infra.PaymentsQueue, dedupe *idempotency.Store, tracker *payments.StatusTracker) (restored int, rest []infra.PendingPayment) {
+	for i, p := range pending {
+		if dedupe.Claim(p.Key, p.Payment).Status != idempotency.StatusCreated {
+			continue
+		}
+		tracker.Received(p.Payment.CorrelationID)
+		if err := q.Requeue(ctx, p); err != nil {
+			log.Printf("[WARN] restauração interrompida: %d de %d pagamentos pendentes", restored, len(pending))
+			return restored, pending[i:]
+		}
+		tracker.Queued(p.Payment.CorrelationID)
+		restored++
+	}
+	return restored, nil
+}
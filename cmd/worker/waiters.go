@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"payment-proxy/internal/transport"
+	"sync"
+	"time"
+)
+
+type waiter struct {
+	peer      transport.Peer
+	requestID uint64 // id do insert que pediu o resultado, ecoado na resposta
+	deadline  time.Time
+}
+
+// outcomeWaiters guarda quais servidores estão segurando uma requisição
+// esperando o resultado final de um pagamento (modo "Prefer: wait")
+type outcomeWaiters struct {
+	mu      sync.Mutex
+	waiting map[string][]waiter
+}
+
+func newOutcomeWaiters() *outcomeWaiters {
+	return &outcomeWaiters{waiting: make(map[string][]waiter)}
+}
+
+func (w *outcomeWaiters) Add(correlationID string, peer transport.Peer, requestID uint64, deadline time.Time) {
+	w.mu.Lock()
+	w.waiting[correlationID] = append(w.waiting[correlationID], waiter{peer: peer, requestID: requestID, deadline: deadline})
+	w.mu.Unlock()
+}
+
+// Take remove e retorna os waiters ainda dentro do prazo para o pagamento
+func (w *outcomeWaiters) Take(correlationID string) []waiter {
+	w.mu.Lock()
+	list, ok := w.waiting[correlationID]
+	if ok {
+		delete(w.waiting, correlationID)
+	}
+	w.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	kept := list[:0]
+	for _, wt := range list {
+		if now.Before(wt.deadline) {
+			kept = append(kept, wt)
+		}
+	}
+	return kept
+}
+
+// Run remove periodicamente os waiters cujo prazo já expirou
+func (w *outcomeWaiters) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.mu.Lock()
+			for id, list := range w.waiting {
+				kept := list[:0]
+				for _, wt := range list {
+					if now.Before(wt.deadline) {
+						kept = append(kept, wt)
+					}
+				}
+				if len(kept) == 0 {
+					delete(w.waiting, id)
+				} else {
+					w.waiting[id] = kept
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *outcomeWaiters) Purge() {
+	w.mu.Lock()
+	w.waiting = make(map[string][]waiter)
+	w.mu.Unlock()
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultMaxSkew é a diferença máxima aceita entre o X-Timestamp e o relógio local
+const DefaultMaxSkew = 5 * time.Minute
+
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidKey         = errors.New("invalid API key")
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrStaleTimestamp     = errors.New("timestamp outside the allowed window")
+	ErrReplayedNonce      = errors.New("nonce already used")
+)
+
+// Request reúne o que é necessário para autenticar uma requisição,
+// independente do servidor HTTP usado
+type Request struct {
+	APIKey    string
+	KeyID     string
+	Timestamp string
+	Nonce     string
+	Signature string
+	Method    string
+	URI       string // path com query string
+	Body      []byte
+}
+
+// Authenticator valida API keys e requisições assinadas com HMAC-SHA256
+type Authenticator struct {
+	keys    *Keystore
+	maxSkew time.Duration
+	nonces  nonceStore
+}
+
+// nonceStore lembra os nonces já usados; Add retorna false para um nonce repetido
+type nonceStore interface {
+	Add(nonce string) bool
+}
+
+func NewAuthenticator(keys *Keystore, maxSkew time.Duration) *Authenticator {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &Authenticator{
+		keys:    keys,
+		maxSkew: maxSkew,
+		// um nonce só precisa ser lembrado enquanto o timestamp dele ainda for aceito
+		nonces: newNonceCache(2 * maxSkew),
+	}
+}
+
+// ShareNonces passa a guardar os nonces no Redis, para que valham em todas as instâncias da api;
+// o cache local fica como fallback. Deve ser chamado antes de Authenticate.
+func (a *Authenticator) ShareNonces(client *redis.Client) {
+	a.nonces = &redisNonces{
+		client:   client,
+		prefix:   "nonce:",
+		ttl:      2 * a.maxSkew,
+		fallback: newNonceCache(2 * a.maxSkew),
+	}
+}
+
+// Authenticate retorna a chave que assinou ou apresentou a requisição.
+// Assinatura HMAC tem precedência sobre API key quando ambas estão presentes.
+func (a *Authenticator) Authenticate(r Request) (Key, error) {
+	if r.Signature != "" {
+		return a.verifySignature(r)
+	}
+	if r.APIKey != "" {
+		key, ok := a.keys.BySecret(r.APIKey)
+		if !ok {
+			return Key{}, ErrInvalidKey
+		}
+		return key, nil
+	}
+	return Key{}, ErrMissingCredentials
+}
+
+func (a *Authenticator) verifySignature(r Request) (Key, error) {
+	if r.KeyID == "" || r.Timestamp == "" || r.Nonce == "" {
+		return Key{}, ErrMissingCredentials
+	}
+	key, ok := a.keys.ByID(r.KeyID)
+	if !ok {
+		return Key{}, ErrInvalidKey
+	}
+
+	ts, err := strconv.ParseInt(r.Timestamp, 10, 64)
+	if err != nil {
+		return Key{}, ErrStaleTimestamp
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > a.maxSkew || skew < -a.maxSkew {
+		return Key{}, ErrStaleTimestamp
+	}
+
+	expected := Sign(key.Secret, r.Method, r.URI, r.Timestamp, r.Nonce, r.Body)
+	got, err := hex.DecodeString(r.Signature)
+	if err != nil || !hmac.Equal(got, expected) {
+		return Key{}, ErrInvalidSignature
+	}
+
+	// só registra o nonce depois da assinatura válida, para que terceiros não consigam "queimar" nonces
+	if !a.nonces.Add(key.ID + ":" + r.Nonce) {
+		return Key{}, ErrReplayedNonce
+	}
+	return key, nil
+}
+
+// Sign calcula HMAC-SHA256(secret, method \n uri \n timestamp \n nonce \n hex(sha256(body))).
+// Clientes enviam o resultado em hexadecimal no header X-Signature.
+func Sign(secret, method, uri, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(uri))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return mac.Sum(nil)
+}
+
+// nonceCache lembra nonces já usados durante ttl.
+// É local a cada instância: sem Redis (ver ShareNonces) o mesmo nonce pode ser aceito uma vez
+// em cada api, mas ainda limitado à janela do timestamp.
+type nonceCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{ttl: ttl, seen: make(map[string]time.Time), lastSweep: time.Now()}
+}
+
+// Add retorna false se o nonce já foi visto dentro do ttl
+func (c *nonceCache) Add(nonce string) bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) > c.ttl/2 {
+		for n, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, n)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if exp, ok := c.seen[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[nonce] = now.Add(c.ttl)
+	return true
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"payment-proxy/internal/keyfile"
+	"sync/atomic"
+	"time"
+)
+
+type Scope string
+
+const (
+	ScopeClient Scope = "client" // cria e consulta pagamentos
+	ScopeAdmin  Scope = "admin"  // tudo do client, mais operações destrutivas (purge)
+)
+
+// Allows indica se uma chave com escopo s pode acessar rotas que exigem required
+func (s Scope) Allows(required Scope) bool {
+	if s == ScopeAdmin {
+		return true
+	}
+	return s == required
+}
+
+// Key é uma credencial. O mesmo secret serve como API key e como chave do HMAC.
+type Key struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+	Scope  Scope  `json:"scope"`
+}
+
+type keyFile struct {
+	Keys []Key `json:"keys"`
+}
+
+type keySet struct {
+	byID     map[string]Key
+	bySecret map[[sha256.Size]byte]Key
+}
+
+// Keystore guarda as chaves válidas. Pode ser recarregado a qualquer momento:
+// para rotacionar, adicione a chave nova ao arquivo, migre os clientes e remova a antiga.
+type Keystore struct {
+	file *keyfile.File
+	keys atomic.Pointer[keySet]
+}
+
+// LoadKeystore lê o arquivo de chaves em path
+func LoadKeystore(path string) (*Keystore, error) {
+	ks := &Keystore{}
+	ks.file = keyfile.New(path, "key file", ks.parse, func() []any {
+		return []any{"keys", len(ks.keys.Load().byID)}
+	})
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Reload relê o arquivo de chaves; em caso de erro as chaves atuais são mantidas
+func (ks *Keystore) Reload() error {
+	return ks.file.Reload()
+}
+
+// Watch recarrega o arquivo sempre que a data de modificação mudar
+func (ks *Keystore) Watch(ctx context.Context, interval time.Duration) {
+	ks.file.Watch(ctx, interval)
+}
+
+// parse valida o conteúdo do arquivo e troca as chaves
+func (ks *Keystore) parse(data []byte) error {
+	path := ks.file.Path()
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+
+	set := &keySet{
+		byID:     make(map[string]Key, len(f.Keys)),
+		bySecret: make(map[[sha256.Size]byte]Key, len(f.Keys)),
+	}
+	for _, k := range f.Keys {
+		if k.ID == "" || k.Secret == "" {
+			return fmt.Errorf("invalid key file %s: keys need id and secret", path)
+		}
+		if k.Scope != ScopeClient && k.Scope != ScopeAdmin {
+			return fmt.Errorf("invalid key file %s: key %s has unknown scope %q", path, k.ID, k.Scope)
+		}
+		if _, dup := set.byID[k.ID]; dup {
+			return fmt.Errorf("invalid key file %s: duplicate key id %s", path, k.ID)
+		}
+		set.byID[k.ID] = k
+		set.bySecret[sha256.Sum256([]byte(k.Secret))] = k
+	}
+
+	ks.keys.Store(set)
+	return nil
+}
+
+// ByID busca a chave usada nas assinaturas HMAC
+func (ks *Keystore) ByID(id string) (Key, bool) {
+	k, ok := ks.keys.Load().byID[id]
+	return k, ok
+}
+
+// BySecret busca a chave apresentada como API key. A busca é feita pelo hash do
+// secret para não depender de comparação byte a byte do valor enviado.
+func (ks *Keystore) BySecret(secret string) (Key, bool) {
+	k, ok := ks.keys.Load().bySecret[sha256.Sum256([]byte(secret))]
+	return k, ok
+}
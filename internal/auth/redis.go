@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisNonces guarda os nonces no Redis (SET NX com ttl), compartilhados entre todas as
+// instâncias da api: uma requisição assinada aceita numa api não pode ser repetida na outra.
+// Se o Redis falhar, usa o cache local em vez de recusar o tráfego.
+type redisNonces struct {
+	client   *redis.Client
+	prefix   string
+	ttl      time.Duration
+	fallback *nonceCache
+	lastWarn atomic.Int64 // unix ns do último aviso de falha, para não inundar o log
+}
+
+func (n *redisNonces) Add(nonce string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	ok, err := n.client.SetNX(ctx, n.prefix+nonce, 1, n.ttl).Result()
+	if err != nil {
+		if now := time.Now().UnixNano(); now-n.lastWarn.Load() > int64(10*time.Second) {
+			n.lastWarn.Store(now)
+			slog.Warn("redis nonce store unavailable, using local cache", "error", err)
+		}
+		return n.fallback.Add(nonce)
+	}
+	return ok
+}
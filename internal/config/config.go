@@ -0,0 +1,378 @@
+// Package config centraliza a configuração da api e do worker.
+// As camadas são aplicadas nesta ordem, cada uma sobrescrevendo a anterior:
+// defaults, arquivo JSON (-config ou CONFIG_FILE), variáveis de ambiente e flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Config é a configuração completa. Cada binário usa apenas as seções que lhe dizem respeito.
+//
+// Tags dos campos: json é a chave no arquivo e, prefixada pela seção, o nome da flag
+// (ex.: -server.listenAddr); env é a variável de ambiente.
+type Config struct {
+	Server    ServerConfig    `json:"server"`
+	Worker    WorkerConfig    `json:"worker"`
+	Queue     QueueConfig     `json:"queue"`
+	Gateways  GatewayConfig   `json:"gateways"`
+	Auth      AuthConfig      `json:"auth"`
+	RateLimit RateLimitConfig `json:"rateLimit"`
+	Redis     RedisConfig     `json:"redis"`
+	Spool     SpoolConfig     `json:"spool"`
+	IPC       IPCConfig       `json:"ipc"`
+	WAL       WALConfig       `json:"wal"`
+	Breaker   BreakerConfig   `json:"breaker"`
+	Limiter   LimiterConfig   `json:"limiter"`
+}
+
+type ServerConfig struct {
+	ListenAddr    string        `json:"listenAddr" env:"LISTEN_ADDR" help:"HTTP listen address of the api"`
+	WorkerAddr    string        `json:"workerAddr" env:"WORKER_ADDR" help:"comma-separated worker addresses (host:port for udp/tcp, socket path for unixgram/unix); payments are sharded among them"`
+	InsertTimeout time.Duration `json:"insertTimeout" env:"INSERT_TIMEOUT" help:"how long to wait for the worker to confirm an insert"`
+	QueryTimeout  time.Duration `json:"queryTimeout" env:"QUERY_TIMEOUT" help:"how long to wait for summary and status replies"`
+	MaxWait       time.Duration `json:"maxWait" env:"MAX_WAIT" help:"upper bound for Prefer: wait on POST /payments"`
+	HelloTimeout  time.Duration `json:"helloTimeout" env:"HELLO_TIMEOUT" help:"how long to wait at startup for each worker to answer the hello handshake; 0 skips it"`
+
+	PartialSummary bool `json:"partialSummary" env:"PARTIAL_SUMMARY" help:"answer /payments-summary with the shards that replied instead of 504 when some do not"`
+
+	WireFormat string `json:"wireFormat" env:"WIRE_FORMAT" help:"encoding of requests to the worker: binary or json (the worker accepts both)"`
+	PoolSize   int    `json:"poolSize" env:"IPC_POOL_SIZE" help:"persistent connections to the worker when worker.transport is tcp or unix"`
+
+	CoalesceLinger time.Duration `json:"coalesceLinger" env:"COALESCE_LINGER" help:"how long an insert waits for others to share its message; 0 sends each insert alone"`
+	CoalesceQueue  int           `json:"coalesceQueue" env:"COALESCE_QUEUE" help:"inserts waiting to be packed; when full new inserts get 503"`
+
+	RetransmitBuffer   int           `json:"retransmitBuffer" env:"RETRANSMIT_BUFFER" help:"inserts kept until the worker acks them; when full new inserts get 503"`
+	RetransmitInterval time.Duration `json:"retransmitInterval" env:"RETRANSMIT_INTERVAL" help:"how long to wait for an ack before sending an insert again"`
+	RetransmitMaxAge   time.Duration `json:"retransmitMaxAge" env:"RETRANSMIT_MAX_AGE" help:"how long an unacked insert is retransmitted before it is given up"`
+}
+
+type WorkerConfig struct {
+	Transport           string        `json:"transport" env:"IPC_TRANSPORT" help:"api to worker transport: udp, unixgram, unix or tcp (must match on both sides)"`
+	ListenAddr          string        `json:"listenAddr" env:"WORKER_LISTEN_ADDR" help:"listen address of the worker: host:port for udp/tcp, socket path for unixgram/unix"`
+	ReadTimeout         time.Duration `json:"readTimeout" env:"WORKER_READ_TIMEOUT" help:"read deadline, bounds how long shutdown waits"`
+	MaxPacketSize       int           `json:"maxPacketSize" env:"MAX_PACKET_SIZE" help:"largest message read by the worker and packed by the api"`
+	MetricsAddr         string        `json:"metricsAddr" env:"WORKER_METRICS_ADDR" help:"HTTP address for the worker /metrics"`
+	PaymentChanBuffer   int           `json:"paymentChanBuffer" env:"PAYMENT_CHAN_BUFFER" help:"capacity of the incoming payment channel"`
+	DropIfQueueFull     bool          `json:"dropIfQueueFull" env:"DROP_IF_QUEUE_FULL" help:"drop immediately instead of waiting 100ms when the channel is full"`
+	IdempotencyWindow   time.Duration `json:"idempotencyWindow" env:"IDEMPOTENCY_WINDOW" help:"how long a correlationId or Idempotency-Key is remembered"`
+	StatusTTL           time.Duration `json:"statusTTL" env:"STATUS_TTL" help:"how long the status of a processed or failed payment is kept for GET /payments/{id}; at least worker.idempotencyWindow"`
+	HealthCheckInterval time.Duration `json:"healthCheckInterval" env:"HEALTH_CHECK_INTERVAL" help:"interval between gateway health checks"`
+	ReplayWindow        time.Duration `json:"replayWindow" env:"REPLAY_WINDOW" help:"how long the reply to an insert is kept to answer retransmissions; must exceed server.retransmitMaxAge"`
+	ShutdownTimeout     time.Duration `json:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT" help:"how long queued payments keep being processed after SIGTERM before the rest is saved"`
+	PendingFile         string        `json:"pendingFile" env:"PENDING_FILE" help:"file where payments still queued at shutdown are saved and loaded from on the next start; empty discards them"`
+}
+
+type QueueConfig struct {
+	Workers        int           `json:"workers" env:"QUEUE_WORKERS" help:"goroutines taking payments from the queues; in-flight calls are bounded by limiter; 0 uses 2 * limiter.max"`
+	RetryBuffer    int           `json:"retryBuffer" env:"RETRY_BUFFER" help:"capacity of the retry channel"`
+	MaxRetries     int           `json:"maxRetries" env:"MAX_RETRIES" help:"failed gateway calls before a payment is moved to the dead-letter store; 0 retries forever"`
+	BaseRetryDelay time.Duration `json:"baseRetryDelay" env:"BASE_RETRY_DELAY" help:"delay before the first retry of a payment; doubles on each retry"`
+	MaxRetryDelay  time.Duration `json:"maxRetryDelay" env:"MAX_RETRY_DELAY" help:"upper bound of the retry delay"`
+	RetryJitter    float64       `json:"retryJitter" env:"RETRY_JITTER" help:"fraction of each retry delay drawn at random (0 to 1) so payments that failed together are spread out"`
+	DeadLetters    int           `json:"deadLetters" env:"DEAD_LETTERS" help:"dead-lettered payments kept for inspection and replay; the oldest is dropped when full"`
+}
+
+type GatewayConfig struct {
+	DefaultURL  string        `json:"defaultUrl" env:"GATEWAY_DEFAULT_URL" help:"base URL of the default payment processor"`
+	FallbackURL string        `json:"fallbackUrl" env:"GATEWAY_FALLBACK_URL" help:"base URL of the fallback payment processor"`
+	Timeout     time.Duration `json:"timeout" env:"GATEWAY_TIMEOUT" help:"HTTP timeout for gateway calls"`
+}
+
+type AuthConfig struct {
+	KeysFile       string        `json:"keysFile" env:"AUTH_KEYS_FILE" help:"JSON file with API keys; empty disables authentication"`
+	MaxSkew        time.Duration `json:"maxSkew" env:"AUTH_MAX_SKEW" help:"accepted clock skew for signed requests"`
+	ReloadInterval time.Duration `json:"reloadInterval" env:"AUTH_RELOAD_INTERVAL" help:"how often the keys file is checked for changes"`
+}
+
+type RateLimitConfig struct {
+	RPS     float64 `json:"rps" env:"RATE_LIMIT_RPS" help:"tokens per second per client; 0 disables rate limiting"`
+	Burst   int     `json:"burst" env:"RATE_LIMIT_BURST" help:"bucket size; 0 uses rps"`
+	Backend string  `json:"backend" env:"RATE_LIMIT_BACKEND" help:"local or redis"`
+}
+
+type RedisConfig struct {
+	Addr string `json:"addr" env:"REDIS_URL" help:"host:port of Redis"`
+}
+
+type SpoolConfig struct {
+	Dir           string        `json:"dir" env:"SPOOL_DIR" help:"directory of the api on-disk spools (one subdirectory per worker) for inserts the worker did not ack; empty disables it"`
+	MaxBytes      int           `json:"maxBytes" env:"SPOOL_MAX_BYTES" help:"disk space each worker spool may use; when full inserts get 503 again"`
+	SegmentBytes  int           `json:"segmentBytes" env:"SPOOL_SEGMENT_BYTES" help:"size of each spool segment file"`
+	Fsync         string        `json:"fsync" env:"SPOOL_FSYNC" help:"when spool writes are synced to disk: always, interval or never"`
+	FsyncInterval time.Duration `json:"fsyncInterval" env:"SPOOL_FSYNC_INTERVAL" help:"how often the spool is synced when fsync is interval"`
+}
+
+// WALConfig é o log de escrita antecipada das filas do worker
+type WALConfig struct {
+	Dir           string        `json:"dir" env:"WAL_DIR" help:"directory of the worker write-ahead log for queued and retried payments; empty disables it"`
+	SegmentBytes  int           `json:"segmentBytes" env:"WAL_SEGMENT_BYTES" help:"size of each wal segment file"`
+	Fsync         string        `json:"fsync" env:"WAL_FSYNC" help:"when wal writes are synced to disk: always, interval or never"`
+	FsyncInterval time.Duration `json:"fsyncInterval" env:"WAL_FSYNC_INTERVAL" help:"how often the wal is synced when fsync is interval"`
+}
+
+// BreakerConfig é o circuit breaker de cada gateway no worker
+type BreakerConfig struct {
+	Failures       int           `json:"failures" env:"BREAKER_FAILURES" help:"consecutive failed gateway calls that open the breaker; 0 disables this trigger"`
+	ErrorRate      float64       `json:"errorRate" env:"BREAKER_ERROR_RATE" help:"fraction of failed calls within breaker.window that opens the breaker; 0 disables this trigger"`
+	MinRequests    int           `json:"minRequests" env:"BREAKER_MIN_REQUESTS" help:"calls within breaker.window needed before the error rate is considered"`
+	Window         time.Duration `json:"window" env:"BREAKER_WINDOW" help:"sliding window for the error rate"`
+	OpenTimeout    time.Duration `json:"openTimeout" env:"BREAKER_OPEN_TIMEOUT" help:"how long an open breaker rejects calls before letting probes through"`
+	HalfOpenProbes int           `json:"halfOpenProbes" env:"BREAKER_HALF_OPEN_PROBES" help:"concurrent probe calls while half-open; this many successes close the breaker"`
+}
+
+// LimiterConfig é o limite adaptativo de chamadas simultâneas a cada gateway no worker
+type LimiterConfig struct {
+	Initial   int     `json:"initial" env:"LIMITER_INITIAL" help:"concurrent calls allowed to each gateway at startup"`
+	Min       int     `json:"min" env:"LIMITER_MIN" help:"lower bound of the concurrency limit of each gateway"`
+	Max       int     `json:"max" env:"LIMITER_MAX" help:"upper bound of the concurrency limit of each gateway"`
+	Backoff   float64 `json:"backoff" env:"LIMITER_BACKOFF" help:"factor the limit is multiplied by when a call fails or latency rises (0 to 1)"`
+	Tolerance float64 `json:"tolerance" env:"LIMITER_TOLERANCE" help:"average latency above this multiple of the recent minimum counts as overload"`
+}
+
+// IPCConfig autentica as mensagens entre api e worker; vale para os dois papéis
+type IPCConfig struct {
+	KeysFile       string        `json:"keysFile" env:"IPC_KEYS_FILE" help:"JSON file with the shared keys that sign api/worker messages; empty accepts unsigned messages"`
+	MaxSkew        time.Duration `json:"maxSkew" env:"IPC_MAX_SKEW" help:"how old (or how far in the future) a signed message may be"`
+	ReloadInterval time.Duration `json:"reloadInterval" env:"IPC_RELOAD_INTERVAL" help:"how often the ipc keys file is checked for changes"`
+}
+
+// WorkerAddrs separa server.workerAddr nos endereços de cada worker
+func (s ServerConfig) WorkerAddrs() []string {
+	var addrs []string
+	for _, addr := range strings.Split(s.WorkerAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Defaults retorna os valores usados quando nada é configurado
+func Defaults() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddr:    ":9999",
+			WorkerAddr:    "172.25.0.12:9000",
+			InsertTimeout: 500 * time.Millisecond,
+			QueryTimeout:  1 * time.Second,
+			MaxWait:       10 * time.Second,
+			HelloTimeout:  10 * time.Second,
+			WireFormat:    "binary",
+			PoolSize:      2,
+
+			CoalesceLinger: 1 * time.Millisecond,
+			CoalesceQueue:  10000,
+
+			RetransmitBuffer:   10000,
+			RetransmitInterval: 200 * time.Millisecond,
+			RetransmitMaxAge:   1 * time.Minute,
+		},
+		Worker: WorkerConfig{
+			Transport:           "udp",
+			ListenAddr:          ":9000",
+			ReadTimeout:         1 * time.Second,
+			MaxPacketSize:       8192,
+			MetricsAddr:         ":9100",
+			PaymentChanBuffer:   50000,
+			DropIfQueueFull:     false,
+			IdempotencyWindow:   5 * time.Minute,
+			StatusTTL:           10 * time.Minute,
+			HealthCheckInterval: 5 * time.Second,
+			ReplayWindow:        2 * time.Minute,
+			ShutdownTimeout:     5 * time.Second,
+			PendingFile:         "pending-payments.jsonl",
+		},
+		Queue: QueueConfig{
+			Workers:        0, // 2 * limiter.max
+			RetryBuffer:    16384,
+			MaxRetries:     10000,
+			BaseRetryDelay: 100 * time.Millisecond,
+			MaxRetryDelay:  2 * time.Second,
+			RetryJitter:    0.2,
+			DeadLetters:    10000,
+		},
+		Gateways: GatewayConfig{
+			Timeout: 10 * time.Second,
+		},
+		Breaker: BreakerConfig{
+			Failures:       5,
+			ErrorRate:      0.5,
+			MinRequests:    20,
+			Window:         10 * time.Second,
+			OpenTimeout:    2 * time.Second,
+			HalfOpenProbes: 3,
+		},
+		Limiter: LimiterConfig{
+			Initial:   min(runtime.NumCPU()*4, 256),
+			Min:       2,
+			Max:       256,
+			Backoff:   0.9,
+			Tolerance: 2,
+		},
+		Auth: AuthConfig{
+			MaxSkew:        5 * time.Minute,
+			ReloadInterval: 10 * time.Second,
+		},
+		RateLimit: RateLimitConfig{
+			Backend: "local",
+		},
+		Spool: SpoolConfig{
+			MaxBytes:      64 << 20,
+			SegmentBytes:  4 << 20,
+			Fsync:         "interval",
+			FsyncInterval: 100 * time.Millisecond,
+		},
+		IPC: IPCConfig{
+			MaxSkew:        30 * time.Second,
+			ReloadInterval: 10 * time.Second,
+		},
+		WAL: WALConfig{
+			SegmentBytes:  4 << 20,
+			Fsync:         "interval",
+			FsyncInterval: 100 * time.Millisecond,
+		},
+	}
+}
+
+// Role indica qual binário está validando a configuração
+type Role int
+
+const (
+	RoleServer Role = iota
+	RoleWorker
+)
+
+// Validate confere os campos usados por role e retorna todos os problemas encontrados
+func (c *Config) Validate(role Role) error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	checkAddr := func(name, addr string) {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid address %q", name, addr))
+		}
+	}
+	// endereços do ipc dependem do transporte: unixgram e unix usam o caminho do socket
+	checkIPCAddr := func(name, addr string) {
+		switch c.Worker.Transport {
+		case "udp", "tcp":
+			checkAddr(name, addr)
+		case "unixgram", "unix":
+			check(addr != "", "%s: socket path is required for transport %s", name, c.Worker.Transport)
+		}
+	}
+
+	if c.IPC.KeysFile != "" {
+		check(c.IPC.MaxSkew > 0, "ipc.maxSkew must be positive")
+		check(c.IPC.ReloadInterval > 0, "ipc.reloadInterval must be positive")
+	}
+
+	switch role {
+	case RoleServer:
+		checkAddr("server.listenAddr", c.Server.ListenAddr)
+		seen := make(map[string]bool)
+		for _, addr := range c.Server.WorkerAddrs() {
+			checkIPCAddr("server.workerAddr", addr)
+			check(!seen[addr], "server.workerAddr: %q listed twice", addr)
+			seen[addr] = true
+		}
+		check(len(seen) > 0, "server.workerAddr: at least one worker is required")
+		check(c.Server.InsertTimeout > 0, "server.insertTimeout must be positive")
+		check(c.Server.QueryTimeout > 0, "server.queryTimeout must be positive")
+		check(c.Server.MaxWait >= 0, "server.maxWait must not be negative")
+		check(c.Server.HelloTimeout >= 0, "server.helloTimeout must not be negative")
+		check(c.Server.WireFormat == "binary" || c.Server.WireFormat == "json",
+			"server.wireFormat must be binary or json, got %q", c.Server.WireFormat)
+		check(c.Server.PoolSize > 0, "server.poolSize must be positive")
+		check(c.Server.CoalesceLinger >= 0, "server.coalesceLinger must not be negative")
+		check(c.Server.CoalesceQueue > 0, "server.coalesceQueue must be positive")
+		check(c.Server.RetransmitBuffer > 0, "server.retransmitBuffer must be positive")
+		check(c.Server.RetransmitInterval > 0, "server.retransmitInterval must be positive")
+		check(c.Server.RetransmitMaxAge >= c.Server.RetransmitInterval,
+			"server.retransmitMaxAge must be at least server.retransmitInterval")
+		check(c.Auth.MaxSkew > 0, "auth.maxSkew must be positive")
+		check(c.Auth.ReloadInterval > 0, "auth.reloadInterval must be positive")
+		check(c.RateLimit.RPS >= 0, "rateLimit.rps must not be negative")
+		check(c.RateLimit.Burst >= 0, "rateLimit.burst must not be negative")
+		check(c.RateLimit.Backend == "local" || c.RateLimit.Backend == "redis",
+			"rateLimit.backend must be local or redis, got %q", c.RateLimit.Backend)
+		if c.RateLimit.RPS > 0 && c.RateLimit.Backend == "redis" {
+			check(c.Redis.Addr != "", "redis.addr is required when rateLimit.backend is redis")
+		}
+		if c.Spool.Dir != "" {
+			check(c.Spool.SegmentBytes > 0, "spool.segmentBytes must be positive")
+			check(c.Spool.MaxBytes >= c.Spool.SegmentBytes, "spool.maxBytes must be at least spool.segmentBytes")
+			check(c.Spool.Fsync == "always" || c.Spool.Fsync == "interval" || c.Spool.Fsync == "never",
+				"spool.fsync must be always, interval or never, got %q", c.Spool.Fsync)
+			if c.Spool.Fsync == "interval" {
+				check(c.Spool.FsyncInterval > 0, "spool.fsyncInterval must be positive")
+			}
+		}
+
+	case RoleWorker:
+		checkIPCAddr("worker.listenAddr", c.Worker.ListenAddr)
+		checkAddr("worker.metricsAddr", c.Worker.MetricsAddr)
+		check(c.Worker.ReadTimeout > 0, "worker.readTimeout must be positive")
+		check(c.Worker.PaymentChanBuffer > 0, "worker.paymentChanBuffer must be positive")
+		check(c.Worker.IdempotencyWindow > 0, "worker.idempotencyWindow must be positive")
+		// um duplicado com Prefer: wait responde com o status guardado
+		check(c.Worker.StatusTTL >= c.Worker.IdempotencyWindow, "worker.statusTTL must be at least worker.idempotencyWindow")
+		check(c.Worker.HealthCheckInterval > 0, "worker.healthCheckInterval must be positive")
+		check(c.Worker.ReplayWindow > 0, "worker.replayWindow must be positive")
+		check(c.Worker.ShutdownTimeout >= 0, "worker.shutdownTimeout must not be negative")
+		if c.WAL.Dir != "" {
+			check(c.WAL.SegmentBytes > 0, "wal.segmentBytes must be positive")
+			check(c.WAL.Fsync == "always" || c.WAL.Fsync == "interval" || c.WAL.Fsync == "never",
+				"wal.fsync must be always, interval or never, got %q", c.WAL.Fsync)
+			if c.WAL.Fsync == "interval" {
+				check(c.WAL.FsyncInterval > 0, "wal.fsyncInterval must be positive")
+			}
+		}
+		check(c.Queue.Workers >= 0, "queue.workers must not be negative")
+		check(c.Queue.RetryBuffer > 0, "queue.retryBuffer must be positive")
+		check(c.Queue.MaxRetries >= 0, "queue.maxRetries must not be negative")
+		check(c.Queue.BaseRetryDelay >= 0, "queue.baseRetryDelay must not be negative")
+		check(c.Queue.MaxRetryDelay >= c.Queue.BaseRetryDelay, "queue.maxRetryDelay must not be smaller than queue.baseRetryDelay")
+		check(c.Queue.RetryJitter >= 0 && c.Queue.RetryJitter <= 1, "queue.retryJitter must be between 0 and 1")
+		check(c.Queue.DeadLetters > 0, "queue.deadLetters must be positive")
+		check(c.Gateways.DefaultURL != "", "gateways.defaultUrl is required (GATEWAY_DEFAULT_URL)")
+		check(c.Gateways.FallbackURL != "", "gateways.fallbackUrl is required (GATEWAY_FALLBACK_URL)")
+		check(c.Gateways.Timeout > 0, "gateways.timeout must be positive")
+		check(c.Breaker.Failures >= 0, "breaker.failures must not be negative")
+		check(c.Breaker.ErrorRate >= 0 && c.Breaker.ErrorRate <= 1, "breaker.errorRate must be between 0 and 1")
+		if c.Breaker.ErrorRate > 0 {
+			check(c.Breaker.MinRequests > 0, "breaker.minRequests must be positive")
+			check(c.Breaker.Window > 0, "breaker.window must be positive")
+		}
+		check(c.Limiter.Min > 0, "limiter.min must be positive")
+		check(c.Limiter.Max >= c.Limiter.Min, "limiter.max must not be smaller than limiter.min")
+		check(c.Limiter.Initial >= c.Limiter.Min && c.Limiter.Initial <= c.Limiter.Max,
+			"limiter.initial must be between limiter.min and limiter.max")
+		check(c.Limiter.Backoff > 0 && c.Limiter.Backoff < 1, "limiter.backoff must be between 0 and 1")
+		check(c.Limiter.Tolerance > 1, "limiter.tolerance must be greater than 1")
+		if c.Breaker.Failures > 0 || c.Breaker.ErrorRate > 0 {
+			check(c.Breaker.OpenTimeout > 0, "breaker.openTimeout must be positive")
+			check(c.Breaker.HalfOpenProbes > 0, "breaker.halfOpenProbes must be positive")
+		}
+	}
+
+	// transporte e tamanho do pacote valem para os dois lados: a api empacota, o worker lê
+	switch c.Worker.Transport {
+	case "udp", "unixgram", "unix", "tcp":
+	default:
+		check(false, "worker.transport must be udp, unixgram, unix or tcp, got %q", c.Worker.Transport)
+	}
+	check(c.Worker.MaxPacketSize >= 512 && c.Worker.MaxPacketSize <= 65507,
+		"worker.maxPacketSize must be between 512 and 65507, got %d", c.Worker.MaxPacketSize)
+
+	return errors.Join(errs...)
+}
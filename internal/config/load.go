@@ -0,0 +1,218 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field descreve um campo configurável de uma seção
+type field struct {
+	section string
+	key     string
+	env     string
+	help    string
+	value   reflect.Value
+}
+
+func (f field) path() string {
+	return f.section + "." + f.key
+}
+
+// fields percorre as seções de c na ordem em que estão declaradas
+func (c *Config) fields() []field {
+	var out []field
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		sectionName := root.Type().Field(i).Tag.Get("json")
+		for j := 0; j < section.NumField(); j++ {
+			sf := section.Type().Field(j)
+			out = append(out, field{
+				section: sectionName,
+				key:     sf.Tag.Get("json"),
+				env:     sf.Tag.Get("env"),
+				help:    sf.Tag.Get("help"),
+				value:   section.Field(j),
+			})
+		}
+	}
+	return out
+}
+
+// Load monta a configuração a partir dos defaults, do arquivo, do ambiente e de args
+// (normalmente os.Args[1:]). Não valida; quem chama decide o Role.
+func Load(name string, args []string) (*Config, error) {
+	cfg := Defaults()
+	fields := cfg.fields()
+
+	// as flags são lidas primeiro só para descobrir -config; os valores são aplicados por último
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON config file (env CONFIG_FILE)")
+	flagValues := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		usage := f.help
+		if f.env != "" {
+			usage += " (env " + f.env + ")"
+		}
+		flagValues[f.path()] = fs.String(f.path(), "", usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if v, ok := os.LookupEnv(f.env); ok && v != "" {
+			if err := setString(f.value, v); err != nil {
+				return nil, fmt.Errorf("env %s: %w", f.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		p, ok := flagValues[fl.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, f := range fields {
+			if f.path() == fl.Name {
+				if err := setString(f.value, *p); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %w", fl.Name, err)
+				}
+				return
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+	return cfg, nil
+}
+
+// loadFile aplica apenas as chaves presentes no arquivo; chaves desconhecidas são erro
+// para que um typo não passe despercebido
+func (c *Config) loadFile(path string, fields []field) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	var raw map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	byPath := make(map[string]field, len(fields))
+	for _, f := range fields {
+		byPath[f.path()] = f
+	}
+
+	var unknown []string
+	for section, values := range raw {
+		for key, v := range values {
+			f, ok := byPath[section+"."+key]
+			if !ok {
+				unknown = append(unknown, section+"."+key)
+				continue
+			}
+			if err := setJSON(f.value, v); err != nil {
+				return fmt.Errorf("config file %s: %s: %w", path, f.path(), err)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("config file %s: unknown keys %s", path, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// setJSON aceita durações como string ("250ms") além do tipo nativo do campo
+func setJSON(v reflect.Value, raw json.RawMessage) error {
+	if v.Type() == durationType {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return fmt.Errorf("duration must be a string like \"500ms\"")
+		}
+		return setString(v, s)
+	}
+	return json.Unmarshal(raw, v.Addr().Interface())
+}
+
+func setString(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// Dump retorna a configuração efetiva em JSON de uma linha, no mesmo formato aceito pelo
+// arquivo de configuração
+func (c *Config) Dump() string {
+	out := make(map[string]map[string]interface{})
+	for _, f := range c.fields() {
+		if out[f.section] == nil {
+			out[f.section] = make(map[string]interface{})
+		}
+		var v interface{}
+		switch {
+		case f.value.Type() == durationType:
+			v = time.Duration(f.value.Int()).String()
+		default:
+			v = f.value.Interface()
+		}
+		out[f.section][f.key] = v
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(out)
+	return strings.TrimSpace(buf.String())
+}
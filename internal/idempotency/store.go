@@ -0,0 +1,202 @@
+package idempotency
+
+import (
+	"context"
+	"hash/fnv"
+	"payment-proxy/internal/payments/entities"
+	"sync"
+	"time"
+)
+
+const shardCount = 256
+
+// DefaultWindow é a janela de deduplicação usada quando nenhuma é configurada
+const DefaultWindow = 5 * time.Minute
+
+type Status string
+
+const (
+	StatusCreated   Status = "created"   // primeira vez que a chave foi vista
+	StatusDuplicate Status = "duplicate" // mesma chave e mesmo payload dentro da janela
+	StatusConflict  Status = "conflict"  // mesma chave com payload diferente
+	StatusRejected  Status = "rejected"  // worker não conseguiu aceitar o pagamento
+)
+
+// Record guarda o resultado original de um pagamento aceito
+type Record struct {
+	Key           string    `json:"key"`
+	CorrelationID string    `json:"correlationId"`
+	Amount        float64   `json:"amount"`
+	ReceivedAt    time.Time `json:"receivedAt"`
+}
+
+// Result é a resposta do worker para um insert
+type Result struct {
+	Status Status `json:"status"`
+	Record Record `json:"record"`
+}
+
+type entry struct {
+	record    Record
+	expiresAt time.Time
+}
+
+type shard struct {
+	sync.Mutex
+	store map[string]entry
+}
+
+// Store deduplica pagamentos por correlationId e, opcionalmente, por Idempotency-Key.
+// Os registros expiram depois de window.
+type Store struct {
+	window time.Duration
+	shards [shardCount]*shard
+}
+
+func NewStore(window time.Duration) *Store {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	s := &Store{window: window}
+	for i := 0; i < shardCount; i++ {
+		s.shards[i] = &shard{store: make(map[string]entry, 256)}
+	}
+	return s
+}
+
+func (s *Store) shardIndex(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % shardCount
+}
+
+// Claim registra o pagamento sob key e sob o próprio correlationId de forma atômica.
+// Se qualquer um deles já existir dentro da janela, retorna o registro original
+// com StatusDuplicate (mesmo payload) ou StatusConflict (payload diferente).
+func (s *Store) Claim(key string, p entities.Payment) Result {
+	if key == "" {
+		key = p.CorrelationID
+	}
+	now := time.Now().UTC()
+
+	keys := storeKeys(key, p.CorrelationID)
+	unlock := s.lock(keys)
+	defer unlock()
+
+	for _, k := range keys {
+		sh := s.shards[s.shardIndex(k)]
+		e, ok := sh.store[k]
+		if !ok || now.After(e.expiresAt) {
+			continue
+		}
+		if e.record.CorrelationID == p.CorrelationID && e.record.Amount == p.Amount {
+			return Result{Status: StatusDuplicate, Record: e.record}
+		}
+		return Result{Status: StatusConflict, Record: e.record}
+	}
+
+	rec := Record{
+		Key:           key,
+		CorrelationID: p.CorrelationID,
+		Amount:        p.Amount,
+		ReceivedAt:    now,
+	}
+	for _, k := range keys {
+		s.shards[s.shardIndex(k)].store[k] = entry{record: rec, expiresAt: now.Add(s.window)}
+	}
+	return Result{Status: StatusCreated, Record: rec}
+}
+
+// Release desfaz um Claim, usado quando o pagamento não pôde ser enfileirado
+func (s *Store) Release(rec Record) {
+	keys := storeKeys(rec.Key, rec.CorrelationID)
+	unlock := s.lock(keys)
+	defer unlock()
+
+	for _, k := range keys {
+		sh := s.shards[s.shardIndex(k)]
+		if e, ok := sh.store[k]; ok && e.record.ReceivedAt.Equal(rec.ReceivedAt) {
+			delete(sh.store, k)
+		}
+	}
+}
+
+// storeKeys são as entradas de um pagamento nos shards. Chaves e correlationIds têm prefixos
+// diferentes: uma Idempotency-Key igual ao correlationId de outro pagamento não colide com ele.
+func storeKeys(key, correlationID string) []string {
+	keys := []string{"c:" + correlationID}
+	if key != "" && key != correlationID {
+		keys = append(keys, "k:"+key)
+	}
+	return keys
+}
+
+// lock trava os shards das chaves sempre em ordem crescente para evitar deadlock
+func (s *Store) lock(keys []string) func() {
+	idx := make([]uint32, 0, len(keys))
+	for _, k := range keys {
+		i := s.shardIndex(k)
+		dup := false
+		for _, j := range idx {
+			if j == i {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 2 && idx[0] > idx[1] {
+		idx[0], idx[1] = idx[1], idx[0]
+	}
+	for _, i := range idx {
+		s.shards[i].Lock()
+	}
+	return func() {
+		for j := len(idx) - 1; j >= 0; j-- {
+			s.shards[idx[j]].Unlock()
+		}
+	}
+}
+
+// Sweep remove registros expirados e retorna quantos foram removidos
+func (s *Store) Sweep(now time.Time) int {
+	removed := 0
+	for _, sh := range s.shards {
+		sh.Lock()
+		for k, e := range sh.store {
+			if now.After(e.expiresAt) {
+				delete(sh.store, k)
+				removed++
+			}
+		}
+		sh.Unlock()
+	}
+	return removed
+}
+
+// Run executa Sweep periodicamente até ctx ser cancelado
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.Sweep(now.UTC())
+		}
+	}
+}
+
+// Purge remove todos os registros
+func (s *Store) Purge() {
+	for _, sh := range s.shards {
+		sh.Lock()
+		for k := range sh.store {
+			delete(sh.store, k)
+		}
+		sh.Unlock()
+	}
+}
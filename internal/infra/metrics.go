@@ -0,0 +1,20 @@
+package infra
+
+import "payment-proxy/internal/metrics"
+
+var (
+	paymentRetries = metrics.NewCounter("payment_proxy_worker_payment_retries_total",
+		"Payments scheduled for another attempt.")
+	paymentOutcomes = metrics.NewCounterVec("payment_proxy_worker_payments_total",
+		"Payment attempts by gateway and outcome (success, failure, rejected, no_gateway).", "gateway", "outcome")
+	paymentDuration = metrics.NewHistogramVec("payment_proxy_worker_payment_duration_seconds",
+		"Latency of ProcessPayment by gateway.", nil, "gateway")
+	deadLettered = metrics.NewCounterVec("payment_proxy_worker_dead_lettered_total",
+		"Payments moved to the dead-letter store, by reason (max_retries, permanent_error).", "reason")
+	deadLettersEvicted = metrics.NewCounter("payment_proxy_worker_dead_letters_evicted_total",
+		"Dead-lettered payments dropped because the store was full.")
+	deadLettersReplayed = metrics.NewCounter("payment_proxy_worker_dead_letters_replayed_total",
+		"Dead-lettered payments sent back to the queue by an admin.")
+	walErrors = metrics.NewCounterVec("payment_proxy_worker_wal_errors_total",
+		"Write-ahead log failures, by operation (put, checkpoint, reset, decode).", "op")
+)
@@ -2,13 +2,16 @@ package infra
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log"
+	"math/rand/v2"
+	"payment-proxy/internal/config"
 	"payment-proxy/internal/payment_processor"
 	"payment-proxy/internal/payments"
 	"payment-proxy/internal/payments/entities"
-	"runtime"
+	"payment-proxy/internal/wal"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -19,45 +22,76 @@ type PaymentsQueue struct {
 	redisClient    *redis.Client
 	service        *payments.Service
 	gatewayManager *payment_processor.GatewayManager
+	tracker        *payments.StatusTracker
+	deadLetters    *payments.DeadLetterStore
+	cfg            config.QueueConfig
+
+	// wal guarda os pagamentos até o checkpoint; nil sem wal.dir
+	wal *wal.Log
+
+	// onOutcome é chamado quando um pagamento chega a um estado final (processed/failed)
+	onOutcome func(entities.PaymentStatus)
 
 	// canais internos (não usar ponteiro para canal)
-	paymentChan chan entities.Payment
-	retryChan   chan retryJob
+	retryChan chan retryJob   // retries cujo horário chegou
+	retries   *retryScheduler // retries esperando o backoff
 
 	// sync
 	wg     sync.WaitGroup
 	once   sync.Once
-	closed int32
+	input  <-chan PendingPayment
+	cancel context.CancelFunc
+	drain  chan struct{} // fechado por Stop: os workers esvaziam as filas e saem
+
+	finished atomic.Int64 // pagamentos que chegaram a um estado final
 }
 
 type retryJob struct {
+	key      string
 	payment  entities.Payment
 	attempts int
+	history  []entities.Attempt // últimas tentativas, para o dead-letter
+	waits    int                // voltas sem gateway disponível, que só aumentam o backoff
 }
 
-// Configuráveis
-const (
-	defaultPaymentChanBuf = 50000
-	defaultRetryBuf       = 16384
-	maxRetries            = 10000
-	baseRetryDelay        = 100 * time.Millisecond
-)
+// pending é o que o wal e o arquivo de pendentes guardam do job
+func (j retryJob) pending() PendingPayment {
+	return PendingPayment{Key: j.key, Payment: j.payment, Attempts: j.attempts, History: j.history}
+}
+
+// maxAttemptHistory limita quantas tentativas cada pagamento carrega até o dead-letter
+const maxAttemptHistory = 10
 
 // NewPaymentQueue cria uma PaymentsQueue pronta para StartConsumer
-func NewPaymentQueue(ctx context.Context, service *payments.Service, gatewayManager *payment_processor.GatewayManager) *PaymentsQueue {
+func NewPaymentQueue(ctx context.Context, service *payments.Service, gatewayManager *payment_processor.GatewayManager, tracker *payments.StatusTracker, cfg config.QueueConfig) *PaymentsQueue {
+	retryChan := make(chan retryJob, cfg.RetryBuffer)
 	return &PaymentsQueue{
 		service:        service,
 		gatewayManager: gatewayManager,
-		paymentChan:    make(chan entities.Payment, defaultPaymentChanBuf),
-		retryChan:      make(chan retryJob, defaultRetryBuf),
+		tracker:        tracker,
+		deadLetters:    payments.NewDeadLetterStore(cfg.DeadLetters),
+		cfg:            cfg,
+		retryChan:      retryChan,
+		retries:        newRetryScheduler(retryChan),
+		drain:          make(chan struct{}),
 	}
 }
 
+// SetOutcomeHandler registra quem deve ser avisado quando um pagamento termina.
+// Deve ser chamado antes de StartConsumer.
+func (q *PaymentsQueue) SetOutcomeHandler(fn func(entities.PaymentStatus)) {
+	q.onOutcome = fn
+}
+
 // StartConsumer inicia workers que processam pagamentos vindos de inputChan.
 // inputChan normalmente é o canal que recebe pagamentos (ex: do UDP listener).
-func (q *PaymentsQueue) StartConsumer(ctx context.Context, inputChan <-chan entities.Payment) {
-	numWorkers := runtime.NumCPU() * 4
+// Os workers param quando ctx é cancelado ou, esvaziando as filas antes, com Stop.
+func (q *PaymentsQueue) StartConsumer(ctx context.Context, inputChan <-chan PendingPayment) {
+	ctx, q.cancel = context.WithCancel(ctx)
+	q.input = inputChan
+	numWorkers := q.cfg.Workers
 	log.Printf("[INFO] Starting PaymentsQueue with %d workers", numWorkers)
+	go q.retries.run(ctx)
 
 	// start workers
 	for i := 0; i < numWorkers; i++ {
@@ -71,7 +105,7 @@ func (q *PaymentsQueue) StartConsumer(ctx context.Context, inputChan <-chan enti
 }
 
 // startWorker lê tanto de inputChan (novos) quanto de retryChan e processa
-func (q *PaymentsQueue) startWorker(ctx context.Context, id int, inputChan <-chan entities.Payment) {
+func (q *PaymentsQueue) startWorker(ctx context.Context, id int, inputChan <-chan PendingPayment) {
 	defer q.wg.Done()
 	log.Printf("[worker %d] started", id)
 
@@ -81,83 +115,186 @@ func (q *PaymentsQueue) startWorker(ctx context.Context, id int, inputChan <-cha
 			log.Printf("[worker %d] ctx done, exiting", id)
 			return
 
+		case <-q.drain:
+			q.drainQueues(ctx, inputChan)
+			return
+
 		case p, ok := <-inputChan:
 			if !ok {
 				// input channel fechado; drain retry until ctx done
 				log.Printf("[worker %d] input channel closed", id)
 				return
 			}
-			q.processWithRetry(ctx, p, 0)
+			q.processWithRetry(ctx, retryJob{key: p.Key, payment: p.Payment})
 
 		case r, ok := <-q.retryChan:
 			if !ok {
 				// retry channel fechado
 				return
 			}
-			q.processWithRetry(ctx, r.payment, r.attempts)
+			q.processWithRetry(ctx, r)
+		}
+	}
+}
+
+// drainQueues processa o que ainda está nas filas até elas esvaziarem ou ctx ser cancelado
+func (q *PaymentsQueue) drainQueues(ctx context.Context, inputChan <-chan PendingPayment) {
+	for ctx.Err() == nil {
+		select {
+		case p, ok := <-inputChan:
+			if !ok {
+				inputChan = nil
+				continue
+			}
+			q.processWithRetry(ctx, retryJob{key: p.Key, payment: p.Payment})
+		case r := <-q.retryChan:
+			q.processWithRetry(ctx, r)
+		default:
+			return
 		}
 	}
 }
 
 // processWithRetry tenta processar o pagamento e, em caso de falha, agenda retry com backoff
-func (q *PaymentsQueue) processWithRetry(ctx context.Context, p entities.Payment, attempts int) {
+func (q *PaymentsQueue) processWithRetry(ctx context.Context, job retryJob) {
+	p := job.payment
+
 	// obter gateway
 	gateway := q.gatewayManager.GetTheBest()
 	if gateway == nil {
-		// sem gateway disponível -> requeue com backoff imediato
-		q.enqueueRetry(p, attempts)
+		// sem gateway disponível (ou com o circuit breaker aberto) -> requeue com backoff; não
+		// conta como tentativa porque nenhum gateway foi chamado, e o wal já tem o pagamento como está
+		paymentOutcomes.With("none", "no_gateway").Inc()
+		q.tracker.Retrying(p.CorrelationID, job.attempts, payments.ErrNoGateway)
+		job.waits++
+		q.enqueueRetry(job)
 		return
 	}
 
+	// espera uma vaga no limite adaptativo do gateway; só falha quando a fila está parando
+	if err := q.gatewayManager.Acquire(ctx, gateway); err != nil {
+		q.enqueueRetry(job)
+		return
+	}
+
+	gatewayLabel := gateway.GetType().String()
+	start := time.Now()
 	_, err := q.service.ProcessPayment(ctx, gateway, p)
+	rtt := time.Since(start)
+	if err != nil && ctx.Err() != nil {
+		// Stop cancelou a chamada: não é falha do gateway nem conta como tentativa
+		q.gatewayManager.Cancel(gateway)
+		q.enqueueRetry(job)
+		return
+	}
+	paymentDuration.With(gatewayLabel).Observe(rtt.Seconds())
+	// erros permanentes (validação, 4xx) não dizem nada contra o gateway
+	q.gatewayManager.Report(gateway, rtt, err == nil || payments.IsPermanent(err))
 	if err != nil {
+		job.attempts++
+		job.history = appendAttempt(job.history, entities.Attempt{At: start.UTC(), Gateway: gatewayLabel, Error: err.Error()})
+		if payments.IsPermanent(err) {
+			paymentOutcomes.With(gatewayLabel, "rejected").Inc()
+			// erro de validação ou 4xx nunca vai passar num retry
+			q.deadLetter(job, entities.DeadLetterPermanent, err)
+			return
+		}
+		paymentOutcomes.With(gatewayLabel, "failure").Inc()
+		if q.cfg.MaxRetries > 0 && job.attempts >= q.cfg.MaxRetries {
+			q.deadLetter(job, entities.DeadLetterMaxRetries, err)
+			return
+		}
 		// se falhar, schedule retry
-		log.Printf("[WARN] process payment failed (attempt %d) CorrelationID=%s err=%v", attempts+1, p.CorrelationID, err)
-		q.enqueueRetry(p, attempts)
+		log.Printf("[WARN] process payment failed (attempt %d) CorrelationID=%s err=%v", job.attempts, p.CorrelationID, err)
+		q.tracker.Retrying(p.CorrelationID, job.attempts, err)
+		q.logPending(job.pending())
+		q.enqueueRetry(job)
 		return
 	}
 
 	// sucesso
-	// opcional: métricas aqui
+	paymentOutcomes.With(gatewayLabel, "success").Inc()
+	q.tracker.Processed(p.CorrelationID, gateway.GetType(), job.attempts+1)
+	q.checkpoint(p.CorrelationID)
+	q.finished.Add(1)
+	q.notifyOutcome(p.CorrelationID)
 }
 
-// enqueueRetry coloca o job no retryChan com attempts+1 usando backoff não-bloqueante
-func (q *PaymentsQueue) enqueueRetry(p entities.Payment, attempts int) {
-	attempts++
-	// if attempts > maxRetries {
-	// 	log.Printf("[ERROR] max retries reached for payment %s — dropping", p.CorrelationID)
-	// 	return
-	// }
+// appendAttempt acrescenta a ao histórico mantendo só as últimas maxAttemptHistory tentativas.
+// Sempre copia: o slice anterior pode estar no dead-letter ou em outro retryJob.
+func appendAttempt(history []entities.Attempt, a entities.Attempt) []entities.Attempt {
+	if len(history) >= maxAttemptHistory {
+		history = history[len(history)-maxAttemptHistory+1:]
+	}
+	out := make([]entities.Attempt, len(history), len(history)+1)
+	copy(out, history)
+	return append(out, a)
+}
 
-	// calcula delay exponencial
-	//delay := time.Duration(1<<uint(attempts-1)) * baseRetryDelay
-	delay := baseRetryDelay
+// deadLetter tira o pagamento da fila e o guarda em DeadLetters para inspeção e replay
+func (q *PaymentsQueue) deadLetter(job retryJob, reason entities.DeadLetterReason, err error) {
+	p := job.payment
+	deadLettered.With(string(reason)).Inc()
+	log.Printf("[ERROR] payment dead-lettered CorrelationID=%s reason=%s attempts=%d err=%v", p.CorrelationID, reason, job.attempts, err)
+	q.storeDeadLetter(entities.DeadLetter{
+		Key:       job.key,
+		Payment:   p,
+		Reason:    reason,
+		LastError: err.Error(),
+		Attempts:  job.attempts,
+		History:   job.history,
+		DeadAt:    time.Now().UTC(),
+	})
+	q.tracker.Failed(p.CorrelationID, job.attempts, err)
+	q.finished.Add(1)
+	q.notifyOutcome(p.CorrelationID)
+}
 
-	// Para não bloquear o caller, tentamos enfileirar com goroutine que aguarda o delay
-	select {
-	case q.retryChan <- retryJob{payment: p, attempts: attempts}:
-		// enfileirou imediatamente — sem delay (já será processado conforme ordem).
-		// Podemos optar por não fazer isto e sempre esperar, mas manter simples aqui.
-	default:
-		// quando retryChan estiver cheio, enfileiramos com espera assíncrona
-		go func(p entities.Payment, attempts int, delay time.Duration) {
-			time.Sleep(delay)
-			// tentativa de colocar no canal (bloqueia se estiver cheio)
-			select {
-			case q.retryChan <- retryJob{payment: p, attempts: attempts}:
-				// ok
-			default:
-				// se ainda estiver cheio, aguarda por um curto período e retenta, para evitar perder
-				select {
-				case q.retryChan <- retryJob{payment: p, attempts: attempts}:
-				case <-time.After(200 * time.Millisecond):
-					log.Printf("[ERROR] retryChan full, dropping payment %s after wait", p.CorrelationID)
-				}
-			}
-		}(p, attempts, delay)
+// storeDeadLetter guarda dl em DeadLetters e no wal; o que for descartado para abrir espaço
+// sai também do wal
+func (q *PaymentsQueue) storeDeadLetter(dl entities.DeadLetter) {
+	q.logDeadLetter(dl)
+	if evicted := q.deadLetters.Add(dl); evicted != "" {
+		deadLettersEvicted.Inc()
+		log.Printf("[WARN] dead-letter store full, dropped CorrelationID=%s", evicted)
+		q.checkpoint(evicted)
+	}
+}
+
+// notifyOutcome repassa o estado final do pagamento ao handler registrado, se houver
+func (q *PaymentsQueue) notifyOutcome(correlationID string) {
+	if q.onOutcome == nil {
+		return
+	}
+	if status, ok := q.tracker.Get(correlationID); ok {
+		q.onOutcome(status)
 	}
 }
 
+// enqueueRetry agenda o job para depois do backoff; nunca bloqueia
+func (q *PaymentsQueue) enqueueRetry(job retryJob) {
+	paymentRetries.Inc()
+	q.retries.schedule(job, q.retryDelay(job.attempts+job.waits))
+}
+
+// retryDelay é a espera antes do retry n (1 = o primeiro): queue.baseRetryDelay dobrando a
+// cada retry até queue.maxRetryDelay, menos um sorteio de até queue.retryJitter dela, para
+// que pagamentos que falharam juntos não voltem todos no mesmo instante
+func (q *PaymentsQueue) retryDelay(n int) time.Duration {
+	delay := q.cfg.BaseRetryDelay
+	if delay <= 0 {
+		return 0
+	}
+	for i := 1; i < n && delay < q.cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, q.cfg.MaxRetryDelay)
+	if q.cfg.RetryJitter > 0 {
+		delay -= time.Duration(rand.Float64() * q.cfg.RetryJitter * float64(delay))
+	}
+	return delay
+}
+
 // retryWorker apenas consome retryChan e re-enfileira no fluxo de processamento (a startWorker já lê retryChan)
 // Aqui nos limitamos a manter o canal ativo e visível; poderia agregar métricas/ordenar por timestamp
 // func (q *PaymentsQueue) retryWorker(ctx context.Context) {
@@ -167,13 +304,21 @@ func (q *PaymentsQueue) enqueueRetry(p entities.Payment, attempts int) {
 // 	log.Printf("[retry worker] ctx done, exiting")
 // }
 
+// RetryDepth retorna quantos pagamentos aguardam um retry, no backoff ou no retryChan
+func (q *PaymentsQueue) RetryDepth() int {
+	return len(q.retryChan) + q.retries.Len()
+}
+
 // ClearQueue esvazia os canais (drain) de forma segura. NÃO recria canais.
 func (q *PaymentsQueue) ClearQueue() {
-	// Drain paymentChan
+	// Drain do canal de entrada (worker.paymentChanBuffer), o mesmo lido pelos workers
 	for {
 		select {
-		case <-q.paymentChan:
-			// descarte
+		case _, ok := <-q.input:
+			// descarte; fechado, não há mais o que esvaziar
+			if !ok {
+				goto drainedPayments
+			}
 		default:
 			goto drainedPayments
 		}
@@ -193,31 +338,116 @@ drainedPayments:
 			break
 		}
 	}
-	log.Printf("[info] queues cleared (paymentChan len=%d retryChan len=%d)", len(q.paymentChan), len(q.retryChan))
+	q.retries.drain()
+	if q.wal != nil {
+		if err := q.wal.Reset(); err != nil {
+			walErrors.With("reset").Inc()
+			log.Printf("[ERROR] wal reset failed: %v", err)
+		}
+	}
+	log.Printf("[info] queues cleared (input len=%d retryChan len=%d)", len(q.input), len(q.retryChan))
 }
 
-// Stop cancela os workers (assumindo que o ctx passado a StartConsumer será cancelado) e espera wg
-// Se você quiser um Stop explícito sem depender do ctx, pode implementá-lo para fechar channels e aguardar wg.
-func (q *PaymentsQueue) Stop() {
+// Stop deixa os workers processarem o que está nas filas por até timeout, cancela as chamadas
+// que ainda estiverem em andamento e espera os workers saírem. Retorna quantos pagamentos
+// terminaram durante a espera e os que ficaram nas filas, para serem salvos com SavePending.
+// Quem produz em inputChan precisa ter parado antes.
+func (q *PaymentsQueue) Stop(timeout time.Duration) (drained int, pending []PendingPayment) {
 	q.once.Do(func() {
-		// fechar canais pode ser perigoso se houver produtores externos; aqui assumimos produtores externos (UDP)
-		// preferimos somente aguardar wg se o contexto externo for cancelado.
+		before := q.finished.Load()
+		close(q.drain)
+
+		done := make(chan struct{})
+		go func() {
+			q.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			// chamadas canceladas voltam para o backoff e são salvas abaixo
+			log.Printf("[WARN] queue drain timed out after %s, cancelling in-flight payments", timeout)
+			q.cancel()
+			<-done
+		}
+		q.cancel()
+		drained = int(q.finished.Load() - before)
+
+		// ninguém mais consome: recolhe as filas, inclusive os retries ainda no backoff
+		<-q.retries.done
+		for input := q.input; input != nil; {
+			select {
+			case p, ok := <-input:
+				if ok {
+					pending = append(pending, p)
+					continue
+				}
+			default:
+			}
+			input = nil
+		}
+		for len(q.retryChan) > 0 {
+			r := <-q.retryChan
+			pending = append(pending, r.pending())
+		}
+		for _, r := range q.retries.drain() {
+			pending = append(pending, r.pending())
+		}
 	})
+	return drained, pending
 }
 
-// Expor um helper para que outros componentes possam enviar payments ao queue interno de forma segura
-// (por exemplo, do UDP listener)
-func (q *PaymentsQueue) Enqueue(p entities.Payment) error {
+// Requeue devolve ao retryChan um pagamento salvo por Stop ou pelo wal na execução anterior.
+// Bloqueia enquanto o canal estiver cheio, então os workers precisam estar rodando.
+func (q *PaymentsQueue) Requeue(ctx context.Context, p PendingPayment) error {
+	q.logPending(p)
 	select {
-	case q.paymentChan <- p:
+	case q.retryChan <- retryJob{key: p.Key, payment: p.Payment, attempts: p.Attempts, history: p.History}:
 		return nil
-	default:
-		// canal cheio: podemos optar por bloquear por um curto timeout, ou retornar erro
-		select {
-		case q.paymentChan <- p:
-			return nil
-		case <-time.After(100 * time.Millisecond):
-			return fmt.Errorf("payment queue full")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DeadLetters expõe os pagamentos que a fila desistiu de processar
+func (q *PaymentsQueue) DeadLetters() *payments.DeadLetterStore {
+	return q.deadLetters
+}
+
+// Replay tira o pagamento do dead-letter e o devolve à fila com as tentativas zeradas.
+// Se a fila não tiver vaga antes de ctx acabar, ele volta para o dead-letter.
+func (q *PaymentsQueue) Replay(ctx context.Context, correlationID string) (entities.DeadLetter, bool, error) {
+	dl, ok := q.deadLetters.Remove(correlationID)
+	if !ok {
+		return dl, false, nil
+	}
+	q.tracker.Received(correlationID)
+	if err := q.Requeue(ctx, PendingPayment{Key: dl.Key, Payment: dl.Payment}); err != nil {
+		q.storeDeadLetter(dl)
+		q.tracker.Failed(correlationID, dl.Attempts, errors.New(dl.LastError))
+		return dl, true, err
+	}
+	q.tracker.Queued(correlationID)
+	deadLettersReplayed.Inc()
+	log.Printf("[INFO] dead-lettered payment replayed CorrelationID=%s", correlationID)
+	return dl, true, nil
+}
+
+// DiscardDeadLetter tira o pagamento do dead-letter e do wal
+func (q *PaymentsQueue) DiscardDeadLetter(correlationID string) (entities.DeadLetter, bool) {
+	dl, ok := q.deadLetters.Remove(correlationID)
+	if ok {
+		q.checkpoint(correlationID)
+	}
+	return dl, ok
+}
+
+// RestoreDeadLetters devolve ao dead-letter o que o wal guardava dele na execução anterior
+func (q *PaymentsQueue) RestoreDeadLetters(deadLetters []entities.DeadLetter) {
+	for _, dl := range deadLetters {
+		if evicted := q.deadLetters.Add(dl); evicted != "" {
+			deadLettersEvicted.Inc()
+			q.checkpoint(evicted)
 		}
 	}
 }
@@ -0,0 +1,77 @@
+package infra
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"payment-proxy/internal/payments/entities"
+)
+
+// PendingPayment é um pagamento que entrou na fila e ainda não terminou; é também o que fica
+// salvo quando o worker para. Attempts > 0 indica que ele estava esperando um retry.
+type PendingPayment struct {
+	Key      string             `json:"key,omitempty"` // chave de idempotência do Claim
+	Payment  entities.Payment   `json:"payment"`
+	Attempts int                `json:"attempts,omitempty"`
+	History  []entities.Attempt `json:"history,omitempty"`
+}
+
+// SavePending grava os pagamentos em path, um JSON por linha. O arquivo é escrito ao lado
+// e renomeado no fim, então uma queda no meio da gravação mantém o conteúdo anterior.
+func SavePending(path string, pending []PendingPayment) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for i := range pending {
+		if err := enc.Encode(&pending[i]); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadPending lê o que SavePending gravou em path; sem arquivo não há nada pendente
+func LoadPending(path string) ([]PendingPayment, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pending []PendingPayment
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var p PendingPayment
+		if err := dec.Decode(&p); err != nil {
+			return pending, fmt.Errorf("%s: entry %d: %w", path, len(pending)+1, err)
+		}
+		pending = append(pending, p)
+	}
+	return pending, nil
+}
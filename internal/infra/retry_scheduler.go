@@ -0,0 +1,124 @@
+package infra
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// retryScheduler segura cada retry até a hora dele e então o entrega em out (o retryChan).
+// Um min-heap por horário e uma única goroutine: milhares de retries esperando não custam
+// uma goroutine cada.
+type retryScheduler struct {
+	mu    sync.Mutex
+	queue retryHeap
+	wake  chan struct{} // avisa run que chegou um retry mais cedo que o próximo
+	out   chan<- retryJob
+	done  chan struct{}
+}
+
+type scheduledRetry struct {
+	due time.Time
+	job retryJob
+}
+
+type retryHeap []scheduledRetry
+
+func (h retryHeap) Len() int           { return len(h) }
+func (h retryHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h retryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *retryHeap) Push(x any)        { *h = append(*h, x.(scheduledRetry)) }
+func (h *retryHeap) Pop() any {
+	old := *h
+	n := len(old) - 1
+	item := old[n]
+	old[n] = scheduledRetry{} // solta o pagamento para o GC
+	*h = old[:n]
+	return item
+}
+
+func newRetryScheduler(out chan<- retryJob) *retryScheduler {
+	return &retryScheduler{
+		wake: make(chan struct{}, 1),
+		out:  out,
+		done: make(chan struct{}),
+	}
+}
+
+// schedule entrega job em out depois de delay; nunca bloqueia
+func (s *retryScheduler) schedule(job retryJob, delay time.Duration) {
+	due := time.Now().Add(delay)
+	s.mu.Lock()
+	earliest := len(s.queue) == 0 || due.Before(s.queue[0].due)
+	heap.Push(&s.queue, scheduledRetry{due: due, job: job})
+	s.mu.Unlock()
+	if earliest {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// next tira do heap o primeiro retry se ele já venceu; senão retorna quanto falta para ele
+// (ou -1 com o heap vazio)
+func (s *retryScheduler) next(now time.Time) (retryJob, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return retryJob{}, -1, false
+	}
+	if wait := s.queue[0].due.Sub(now); wait > 0 {
+		return retryJob{}, wait, false
+	}
+	return heap.Pop(&s.queue).(scheduledRetry).job, 0, true
+}
+
+// run entrega os retries vencidos até ctx ser cancelado. Um retry que estava esperando vaga
+// em out volta para o heap, para ser recolhido por drain.
+func (s *retryScheduler) run(ctx context.Context) {
+	defer close(s.done)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		job, wait, ok := s.next(time.Now())
+		if ok {
+			select {
+			case s.out <- job:
+			case <-ctx.Done():
+				s.schedule(job, 0)
+				return
+			}
+			continue
+		}
+
+		if wait < 0 {
+			wait = time.Hour
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain esvazia o heap e retorna os retries que estavam esperando, em ordem de horário
+func (s *retryScheduler) drain() []retryJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]retryJob, 0, len(s.queue))
+	for len(s.queue) > 0 {
+		jobs = append(jobs, heap.Pop(&s.queue).(scheduledRetry).job)
+	}
+	return jobs
+}
+
+func (s *retryScheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
@@ -0,0 +1,88 @@
+package infra
+
+import (
+	"encoding/json"
+	"log"
+	"payment-proxy/internal/payments/entities"
+	"payment-proxy/internal/wal"
+)
+
+// walEntry é o que o wal guarda de cada pagamento: o estado do retry enquanto está na fila e,
+// depois de desistir dele, o dead-letter, que fica no wal até ser descartado ou reenviado
+type walEntry struct {
+	PendingPayment
+	DeadLetter *entities.DeadLetter `json:"deadLetter,omitempty"`
+}
+
+// SetWAL faz a fila registrar em w cada pagamento aceito, reagendado ou no dead-letter e o
+// checkpoint de cada pagamento processado ou descartado. Deve ser chamado antes de StartConsumer.
+func (q *PaymentsQueue) SetWAL(w *wal.Log) {
+	q.wal = w
+}
+
+// LogAccepted registra no wal um pagamento novo, antes de ele entrar na fila
+func (q *PaymentsQueue) LogAccepted(p PendingPayment) error {
+	return q.logPending(p)
+}
+
+// Discard encerra no wal um pagamento registrado que acabou não entrando na fila
+func (q *PaymentsQueue) Discard(correlationID string) {
+	q.checkpoint(correlationID)
+}
+
+func (q *PaymentsQueue) logPending(p PendingPayment) error {
+	return q.logEntry(walEntry{PendingPayment: p})
+}
+
+// logDeadLetter substitui no wal o pagamento pelo seu dead-letter
+func (q *PaymentsQueue) logDeadLetter(dl entities.DeadLetter) error {
+	return q.logEntry(walEntry{PendingPayment: PendingPayment{Key: dl.Key, Payment: dl.Payment}, DeadLetter: &dl})
+}
+
+func (q *PaymentsQueue) logEntry(e walEntry) error {
+	if q.wal == nil {
+		return nil
+	}
+	data, err := json.Marshal(&e)
+	if err == nil {
+		err = q.wal.Put(e.Payment.CorrelationID, data)
+	}
+	if err != nil {
+		walErrors.With("put").Inc()
+		log.Printf("[ERROR] wal put failed CorrelationID=%s err=%v", e.Payment.CorrelationID, err)
+	}
+	return err
+}
+
+// checkpoint marca no wal que o pagamento chegou a um estado final
+func (q *PaymentsQueue) checkpoint(correlationID string) {
+	if q.wal == nil {
+		return
+	}
+	if err := q.wal.Checkpoint(correlationID); err != nil {
+		walErrors.With("checkpoint").Inc()
+		log.Printf("[ERROR] wal checkpoint failed CorrelationID=%s err=%v", correlationID, err)
+	}
+}
+
+// DecodeWAL converte as entradas devolvidas por wal.Open nos pagamentos que ficaram pendentes
+// e nos que estavam no dead-letter. Uma entrada ilegível é descartada com checkpoint, senão ficaria
+// no wal para sempre; as demais são restauradas normalmente.
+func (q *PaymentsQueue) DecodeWAL(entries []wal.Entry) (pending []PendingPayment, deadLetters []entities.DeadLetter) {
+	pending = make([]PendingPayment, 0, len(entries))
+	for _, e := range entries {
+		var entry walEntry
+		if err := json.Unmarshal(e.Data, &entry); err != nil {
+			walErrors.With("decode").Inc()
+			log.Printf("[ERROR] entrada ilegível no wal descartada key=%s err=%v", e.Key, err)
+			q.checkpoint(e.Key)
+			continue
+		}
+		if entry.DeadLetter != nil {
+			deadLetters = append(deadLetters, *entry.DeadLetter)
+			continue
+		}
+		pending = append(pending, entry.PendingPayment)
+	}
+	return pending, deadLetters
+}
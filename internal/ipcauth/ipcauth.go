@@ -0,0 +1,252 @@
+// Package ipcauth autentica as mensagens entre api e worker com HMAC-SHA256.
+//
+// Cada mensagem vai dentro de um envelope:
+//
+//	byte 0          Magic (0xA5, nunca é o início de um JSON nem de um frame de internal/wire)
+//	byte 1          versão do envelope
+//	byte 2          tamanho do id da chave (1 a MaxKeyIDLen)
+//	bytes 3..       id da chave
+//	8 bytes         timestamp em ms desde a época, int64 big-endian
+//	...             mensagem (JSON ou frame binário de internal/wire)
+//	32 bytes finais HMAC-SHA256(secret, tudo que vem antes)
+//
+// O timestamp limita por quanto tempo uma mensagem capturada pode ser reenviada e, dentro da
+// janela, Open recusa um envelope que já tenha aceitado. Como a assinatura é feita no envio, uma
+// retransmissão legítima leva outro timestamp e outro HMAC; só a cópia exata é recusada.
+package ipcauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"payment-proxy/internal/keyfile"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	Magic   byte = 0xA5
+	Version byte = 1
+
+	MaxKeyIDLen  = 64
+	minSecretLen = 16
+
+	// MaxOverhead é o maior acréscimo do envelope ao tamanho da mensagem
+	MaxOverhead = 3 + MaxKeyIDLen + 8 + sha256.Size
+)
+
+var (
+	ErrUnsigned     = errors.New("ipcauth: message is not signed")
+	ErrMalformed    = errors.New("ipcauth: malformed envelope")
+	ErrUnknownKey   = errors.New("ipcauth: unknown key")
+	ErrStale        = errors.New("ipcauth: timestamp outside the allowed window")
+	ErrBadSignature = errors.New("ipcauth: invalid signature")
+	ErrReplayed     = errors.New("ipcauth: message already received")
+)
+
+// Reason resume err para a label das métricas de mensagens rejeitadas
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrUnsigned):
+		return "unsigned"
+	case errors.Is(err, ErrUnknownKey):
+		return "unknown_key"
+	case errors.Is(err, ErrStale):
+		return "stale"
+	case errors.Is(err, ErrBadSignature):
+		return "bad_signature"
+	case errors.Is(err, ErrReplayed):
+		return "replayed"
+	}
+	return "malformed"
+}
+
+// Key é um segredo compartilhado entre apis e workers
+type Key struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+type keyFile struct {
+	SigningKey string `json:"signingKey"`
+	Keys       []Key  `json:"keys"`
+}
+
+type keySet struct {
+	signing *macKey
+	byID    map[string]*macKey
+}
+
+// macKey reaproveita os hash.Hash já iniciados com o secret
+type macKey struct {
+	id   string
+	macs sync.Pool
+}
+
+func newMACKey(k Key) *macKey {
+	secret := []byte(k.Secret)
+	mk := &macKey{id: k.ID}
+	mk.macs.New = func() any { return hmac.New(sha256.New, secret) }
+	return mk
+}
+
+// sum acrescenta a dst o HMAC de data
+func (mk *macKey) sum(dst, data []byte) []byte {
+	mac := mk.macs.Get().(hash.Hash)
+	mac.Reset()
+	mac.Write(data)
+	dst = mac.Sum(dst)
+	mk.macs.Put(mac)
+	return dst
+}
+
+// Keyring assina com a chave em signingKey e aceita mensagens assinadas com qualquer chave do
+// arquivo. Para rotacionar sem parar nada: inclua a chave nova em todos os processos, troque
+// signingKey, e remova a antiga quando as mensagens assinadas com ela já tiverem saído da janela.
+type Keyring struct {
+	file    *keyfile.File
+	maxSkew time.Duration
+	keys    atomic.Pointer[keySet]
+	seen    replayGuard
+}
+
+// Load lê o arquivo de chaves em path; mensagens com timestamp a mais de maxSkew do relógio
+// local são rejeitadas
+func Load(path string, maxSkew time.Duration) (*Keyring, error) {
+	kr := &Keyring{maxSkew: maxSkew}
+	kr.seen.period = 2 * maxSkew
+	kr.file = keyfile.New(path, "ipc key file", kr.parse, func() []any {
+		set := kr.keys.Load()
+		return []any{"keys", len(set.byID), "signingKey", set.signing.id}
+	})
+	if err := kr.Reload(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Reload relê o arquivo de chaves; em caso de erro as chaves atuais são mantidas
+func (kr *Keyring) Reload() error {
+	return kr.file.Reload()
+}
+
+// Watch recarrega o arquivo sempre que a data de modificação mudar
+func (kr *Keyring) Watch(ctx context.Context, interval time.Duration) {
+	kr.file.Watch(ctx, interval)
+}
+
+// parse valida o conteúdo do arquivo e troca as chaves
+func (kr *Keyring) parse(data []byte) error {
+	path := kr.file.Path()
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("invalid ipc key file %s: %w", path, err)
+	}
+
+	set := &keySet{byID: make(map[string]*macKey, len(f.Keys))}
+	for _, k := range f.Keys {
+		if k.ID == "" || len(k.ID) > MaxKeyIDLen {
+			return fmt.Errorf("invalid ipc key file %s: key ids must have 1 to %d bytes", path, MaxKeyIDLen)
+		}
+		if len(k.Secret) < minSecretLen {
+			return fmt.Errorf("invalid ipc key file %s: secret of key %s must have at least %d bytes", path, k.ID, minSecretLen)
+		}
+		if _, dup := set.byID[k.ID]; dup {
+			return fmt.Errorf("invalid ipc key file %s: duplicate key id %s", path, k.ID)
+		}
+		set.byID[k.ID] = newMACKey(k)
+	}
+	if set.signing = set.byID[f.SigningKey]; set.signing == nil {
+		return fmt.Errorf("invalid ipc key file %s: signingKey %q is not in keys", path, f.SigningKey)
+	}
+
+	kr.keys.Store(set)
+	return nil
+}
+
+// Seal devolve msg dentro de um envelope assinado com a chave atual e o horário atual
+func (kr *Keyring) Seal(msg []byte) []byte {
+	k := kr.keys.Load().signing
+	out := make([]byte, 0, 3+len(k.id)+8+len(msg)+sha256.Size)
+	out = append(out, Magic, Version, byte(len(k.id)))
+	out = append(out, k.id...)
+	out = binary.BigEndian.AppendUint64(out, uint64(time.Now().UnixMilli()))
+	out = append(out, msg...)
+	return k.sum(out, out)
+}
+
+// Open confere o envelope e devolve a mensagem dentro dele (um subslice de data).
+// Nada da mensagem é interpretado antes de a assinatura ser validada.
+func (kr *Keyring) Open(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != Magic {
+		return nil, ErrUnsigned
+	}
+	if len(data) < 3 || data[1] != Version {
+		return nil, ErrMalformed
+	}
+	idLen := int(data[2])
+	if idLen == 0 || len(data) < 3+idLen+8+sha256.Size {
+		return nil, ErrMalformed
+	}
+	k, ok := kr.keys.Load().byID[string(data[3:3+idLen])]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	tsEnd := 3 + idLen + 8
+	ts := time.UnixMilli(int64(binary.BigEndian.Uint64(data[3+idLen : tsEnd])))
+	if skew := time.Since(ts); skew > kr.maxSkew || skew < -kr.maxSkew {
+		return nil, ErrStale
+	}
+
+	signed := len(data) - sha256.Size
+	var tag [sha256.Size]byte
+	if !hmac.Equal(k.sum(tag[:0], data[:signed]), data[signed:]) {
+		return nil, ErrBadSignature
+	}
+	if !kr.seen.first(tag) {
+		return nil, ErrReplayed
+	}
+	return data[tsEnd:signed], nil
+}
+
+// replayTagLen é quanto do HMAC identifica um envelope já visto; 128 bits bastam contra colisão
+// e reduzem a memória pela metade
+const replayTagLen = 16
+
+// replayGuard lembra o HMAC dos envelopes aceitos em duas gerações trocadas a cada period. Um
+// envelope aceito tem timestamp de no máximo maxSkew no futuro e deixa de passar no teste de
+// horário maxSkew depois dele; com period = 2*maxSkew ele fica lembrado pelo menos esse tempo.
+type replayGuard struct {
+	mu      sync.Mutex
+	period  time.Duration
+	rotated time.Time
+	current map[[replayTagLen]byte]struct{}
+	prev    map[[replayTagLen]byte]struct{}
+}
+
+// first registra tag e diz se é a primeira vez que ela aparece
+func (g *replayGuard) first(tag [sha256.Size]byte) bool {
+	var key [replayTagLen]byte
+	copy(key[:], tag[:])
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now := time.Now(); g.current == nil || now.Sub(g.rotated) >= g.period {
+		g.prev, g.current, g.rotated = g.current, make(map[[replayTagLen]byte]struct{}), now
+	}
+	if _, ok := g.current[key]; ok {
+		return false
+	}
+	if _, ok := g.prev[key]; ok {
+		return false
+	}
+	g.current[key] = struct{}{}
+	return true
+}
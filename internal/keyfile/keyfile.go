@@ -0,0 +1,80 @@
+// Package keyfile recarrega um arquivo de chaves quando ele muda. Quem usa só interpreta o
+// conteúdo: internal/auth (chaves da api) e internal/ipcauth (chaves do ipc entre api e worker).
+package keyfile
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// File é um arquivo relido por Reload e Watch. parse recebe o conteúdo e troca as chaves de quem
+// usa; se retornar erro, as chaves atuais são mantidas.
+type File struct {
+	path     string
+	name     string // como o arquivo aparece no log (ex.: "ipc key file")
+	parse    func(data []byte) error
+	describe func() []any // atributos do log de cada reload
+
+	mu      sync.Mutex // serializa reloads
+	modTime time.Time
+}
+
+func New(path, name string, parse func(data []byte) error, describe func() []any) *File {
+	return &File{path: path, name: name, parse: parse, describe: describe}
+}
+
+func (f *File) Path() string {
+	return f.path
+}
+
+// Reload relê o arquivo
+func (f *File) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	if err := f.parse(data); err != nil {
+		return err
+	}
+	f.modTime = info.ModTime()
+	return nil
+}
+
+// Watch recarrega o arquivo sempre que a data de modificação mudar
+func (f *File) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(f.path)
+			if err != nil {
+				slog.Error("failed to stat "+f.name, "path", f.path, "error", err)
+				continue
+			}
+			f.mu.Lock()
+			changed := !info.ModTime().Equal(f.modTime)
+			f.mu.Unlock()
+			if !changed {
+				continue
+			}
+			if err := f.Reload(); err != nil {
+				slog.Error("failed to reload "+f.name+", keeping previous keys", "path", f.path, "error", err)
+				continue
+			}
+			slog.Info(f.name+" reloaded", append([]any{"path", f.path}, f.describe()...)...)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package metrics
+
+import "net/http"
+
+// Handler expõe o registry Default em net/http
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		Default.WriteTo(w)
+	})
+}
@@ -0,0 +1,218 @@
+// Package metrics implementa o mínimo do formato texto do Prometheus
+// (counters, gauges e histogramas com labels) sem dependências externas.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType é o Content-Type da exposição em texto
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Default é o registry usado pelos construtores do pacote
+var Default = NewRegistry()
+
+// DefBuckets são os limites padrão dos histogramas de latência, em segundos
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type family interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.families[f.name()]; exists {
+		panic("metrics: duplicate metric " + f.name())
+	}
+	r.families[f.name()] = f
+}
+
+// WriteTo escreve todas as métricas no formato texto do Prometheus, ordenadas por nome
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := make([]family, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		families = append(families, r.families[name])
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// value é um float64 atualizado atomicamente
+type value struct {
+	bits uint64
+}
+
+func (v *value) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, next) {
+			return
+		}
+	}
+}
+
+func (v *value) Set(x float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(x))
+}
+
+func (v *value) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// vec guarda os filhos de uma família com labels, indexados pelos valores das labels
+type vec[T any] struct {
+	labelNames []string
+	newChild   func() T
+	mu         sync.RWMutex
+	children   map[string]*child[T]
+}
+
+type child[T any] struct {
+	labelValues []string
+	metric      T
+}
+
+func newVec[T any](labelNames []string, newChild func() T) *vec[T] {
+	return &vec[T]{labelNames: labelNames, newChild: newChild, children: make(map[string]*child[T])}
+}
+
+func (v *vec[T]) with(labelValues []string) T {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.metric
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.metric
+	}
+	c = &child[T]{labelValues: append([]string(nil), labelValues...), metric: v.newChild()}
+	v.children[key] = c
+	return c.metric
+}
+
+// sorted retorna os filhos ordenados pelos valores das labels
+func (v *vec[T]) sorted() []*child[T] {
+	v.mu.RLock()
+	out := make([]*child[T], 0, len(v.children))
+	for _, c := range v.children {
+		out = append(out, c)
+	}
+	v.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].labelValues, "\xff") < strings.Join(out[j].labelValues, "\xff")
+	})
+	return out
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	w.WriteString("# HELP ")
+	w.WriteString(name)
+	w.WriteByte(' ')
+	w.WriteString(strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	w.WriteString("\n# TYPE ")
+	w.WriteString(name)
+	w.WriteByte(' ')
+	w.WriteString(typ)
+	w.WriteByte('\n')
+}
+
+// writeSample escreve uma linha "name{labels} value". extraName/extraValue permitem
+// acrescentar a label "le" dos buckets de histograma.
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		sep := false
+		for i, ln := range labelNames {
+			if sep {
+				w.WriteByte(',')
+			}
+			writeLabel(w, ln, labelValues[i])
+			sep = true
+		}
+		if extraName != "" {
+			if sep {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabel(w *bufio.Writer, name, val string) {
+	w.WriteString(name)
+	w.WriteString(`="`)
+	w.WriteString(labelEscaper.Replace(val))
+	w.WriteByte('"')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"bufio"
+	"sort"
+	"sync/atomic"
+)
+
+// Counter só cresce
+type Counter struct {
+	v value
+}
+
+func (c *Counter) Inc()              { c.v.Add(1) }
+func (c *Counter) Add(delta float64) { c.v.Add(delta) }
+func (c *Counter) Value() float64    { return c.v.Get() }
+
+// Gauge sobe e desce; se fn estiver definida o valor é lido dela na hora da coleta
+type Gauge struct {
+	v  value
+	fn func() float64
+}
+
+func (g *Gauge) Set(x float64)     { g.v.Set(x) }
+func (g *Gauge) Inc()              { g.v.Add(1) }
+func (g *Gauge) Dec()              { g.v.Add(-1) }
+func (g *Gauge) Add(delta float64) { g.v.Add(delta) }
+
+func (g *Gauge) Value() float64 {
+	if g.fn != nil {
+		return g.fn()
+	}
+	return g.v.Get()
+}
+
+// Histogram conta observações em buckets cumulativos
+type Histogram struct {
+	upperBounds []float64
+	counts      []uint64 // um por bucket, mais o +Inf
+	sum         value
+	count       uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upperBounds: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *Histogram) Observe(x float64) {
+	i := sort.SearchFloat64s(h.upperBounds, x)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(x)
+}
+
+func (h *Histogram) write(w *bufio.Writer, name string, labelNames, labelValues []string) {
+	var cumulative uint64
+	for i, ub := range h.upperBounds {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		writeSample(w, name+"_bucket", labelNames, labelValues, "le", formatFloat(ub), float64(cumulative))
+	}
+	cumulative += atomic.LoadUint64(&h.counts[len(h.upperBounds)])
+	writeSample(w, name+"_bucket", labelNames, labelValues, "le", "+Inf", float64(cumulative))
+	writeSample(w, name+"_sum", labelNames, labelValues, "", "", h.sum.Get())
+	writeSample(w, name+"_count", labelNames, labelValues, "", "", float64(atomic.LoadUint64(&h.count)))
+}
+
+// ---------- famílias ----------
+
+type CounterVec struct {
+	metricName, help string
+	*vec[*Counter]
+}
+
+// NewCounter registra no Default um counter sem labels
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+// NewCounterVec registra no Default um counter com as labels informadas
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{metricName: name, help: help, vec: newVec(labelNames, func() *Counter { return &Counter{} })}
+	Default.register(c)
+	return c
+}
+
+func (c *CounterVec) With(labelValues ...string) *Counter { return c.with(labelValues) }
+func (c *CounterVec) name() string                        { return c.metricName }
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+	for _, ch := range c.sorted() {
+		writeSample(w, c.metricName, c.labelNames, ch.labelValues, "", "", ch.metric.Value())
+	}
+}
+
+type GaugeVec struct {
+	metricName, help string
+	*vec[*Gauge]
+}
+
+// NewGauge registra no Default um gauge sem labels
+func NewGauge(name, help string) *Gauge {
+	return NewGaugeVec(name, help).With()
+}
+
+// NewGaugeVec registra no Default um gauge com as labels informadas
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{metricName: name, help: help, vec: newVec(labelNames, func() *Gauge { return &Gauge{} })}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeVec) With(labelValues ...string) *Gauge { return g.with(labelValues) }
+
+// WithFunc faz o filho com essas labels ler seu valor de fn a cada coleta
+func (g *GaugeVec) WithFunc(fn func() float64, labelValues ...string) {
+	g.with(labelValues).fn = fn
+}
+
+func (g *GaugeVec) name() string { return g.metricName }
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	for _, ch := range g.sorted() {
+		writeSample(w, g.metricName, g.labelNames, ch.labelValues, "", "", ch.metric.Value())
+	}
+}
+
+type HistogramVec struct {
+	metricName, help string
+	*vec[*Histogram]
+}
+
+// NewHistogram registra no Default um histograma sem labels
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return NewHistogramVec(name, help, buckets).With()
+}
+
+// NewHistogramVec registra no Default um histograma com as labels informadas.
+// buckets deve estar em ordem crescente; nil usa DefBuckets.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	h := &HistogramVec{metricName: name, help: help, vec: newVec(labelNames, func() *Histogram { return newHistogram(buckets) })}
+	Default.register(h)
+	return h
+}
+
+func (h *HistogramVec) With(labelValues ...string) *Histogram { return h.with(labelValues) }
+func (h *HistogramVec) name() string                          { return h.metricName }
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+	for _, ch := range h.sorted() {
+		ch.metric.write(w, h.metricName, h.labelNames, ch.labelValues)
+	}
+}
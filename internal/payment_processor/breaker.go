@@ -0,0 +1,201 @@
+package payment_processor
+
+import (
+	"log"
+	"payment-proxy/internal/config"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// breakerBuckets divide a janela da taxa de erro; cada bucket cobre window/breakerBuckets
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	epoch     int64 // índice do bucket desde a época; outro valor significa bucket vencido
+	successes int
+	failures  int
+}
+
+// CircuitBreaker acompanha o resultado das chamadas a um gateway. Fechado, deixa tudo passar e
+// abre com breaker.failures falhas seguidas ou com a taxa de erro da janela acima de
+// breaker.errorRate. Aberto, recusa tudo por breaker.openTimeout; depois fica meio aberto e deixa
+// passar até breaker.halfOpenProbes chamadas: uma falha abre de novo, todas com sucesso fecham.
+type CircuitBreaker struct {
+	name string
+	cfg  config.BreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	consecutive int
+	buckets     [breakerBuckets]breakerBucket
+	openedAt    time.Time
+	probes      int // chamadas em andamento no meio aberto
+	successes   int // sucessos no meio aberto
+}
+
+func NewCircuitBreaker(name string, cfg config.BreakerConfig) *CircuitBreaker {
+	b := &CircuitBreaker{name: name, cfg: cfg}
+	breakerState.With(name).Set(float64(BreakerClosed))
+	return b
+}
+
+func (b *CircuitBreaker) enabled() bool {
+	return b.cfg.Failures > 0 || b.cfg.ErrorRate > 0
+}
+
+// Allow diz se uma chamada pode ser feita agora. No meio aberto cada true ocupa uma vaga de
+// teste, então quem recebe true precisa chamar Record com o resultado.
+func (b *CircuitBreaker) Allow() bool {
+	if !b.enabled() {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.cfg.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// Record registra o resultado de uma chamada liberada por Allow
+func (b *CircuitBreaker) Record(ok bool) {
+	if !b.enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		if b.count(ok, time.Now()) {
+			b.open()
+		}
+	case BreakerHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		if !ok {
+			b.open()
+			return
+		}
+		if b.successes++; b.successes >= b.cfg.HalfOpenProbes {
+			b.reset()
+			b.setState(BreakerClosed)
+		}
+	case BreakerOpen:
+		// chamada iniciada antes de abrir: não muda nada
+	}
+}
+
+// Cancel devolve a vaga de uma chamada liberada por Allow que acabou não sendo feita
+func (b *CircuitBreaker) Cancel() {
+	if !b.enabled() {
+		return
+	}
+	b.mu.Lock()
+	if b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+	b.mu.Unlock()
+}
+
+// State retorna o estado atual sem mudar nada
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// count soma o resultado às falhas seguidas e à janela e diz se o breaker deve abrir
+func (b *CircuitBreaker) count(ok bool, now time.Time) bool {
+	if ok {
+		b.consecutive = 0
+	} else {
+		b.consecutive++
+	}
+	if b.cfg.Failures > 0 && b.consecutive >= b.cfg.Failures {
+		return true
+	}
+	if b.cfg.ErrorRate <= 0 {
+		return false
+	}
+
+	width := int64(b.cfg.Window / breakerBuckets)
+	if width <= 0 {
+		width = 1
+	}
+	epoch := now.UnixNano() / width
+	bucket := &b.buckets[epoch%breakerBuckets]
+	if bucket.epoch != epoch {
+		*bucket = breakerBucket{epoch: epoch}
+	}
+	if ok {
+		bucket.successes++
+	} else {
+		bucket.failures++
+	}
+
+	var total, failures int
+	for i := range b.buckets {
+		if epoch-b.buckets[i].epoch < breakerBuckets {
+			total += b.buckets[i].successes + b.buckets[i].failures
+			failures += b.buckets[i].failures
+		}
+	}
+	return total >= b.cfg.MinRequests && float64(failures) >= b.cfg.ErrorRate*float64(total)
+}
+
+func (b *CircuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.reset()
+	b.setState(BreakerOpen)
+}
+
+// reset zera os contadores ao trocar de estado: a janela anterior não vale para o próximo ciclo
+func (b *CircuitBreaker) reset() {
+	b.consecutive = 0
+	b.buckets = [breakerBuckets]breakerBucket{}
+	b.probes = 0
+	b.successes = 0
+}
+
+func (b *CircuitBreaker) setState(s BreakerState) {
+	if b.state == s {
+		return
+	}
+	log.Printf("[WARN] circuit breaker of gateway %s: %s -> %s", b.name, b.state, s)
+	b.state = s
+	breakerState.With(b.name).Set(float64(s))
+	breakerTransitions.With(b.name, s.String()).Inc()
+}
@@ -0,0 +1,133 @@
+package payment_processor
+
+import (
+	"payment-proxy/internal/config"
+	"testing"
+	"time"
+)
+
+// passos de um cenário do breaker
+const (
+	stepOK     = "ok"     // Allow libera e Record(true)
+	stepFail   = "fail"   // Allow libera e Record(false)
+	stepAllow  = "allow"  // Allow libera; a chamada fica em andamento
+	stepDeny   = "deny"   // Allow recusa
+	stepCancel = "cancel" // Cancel de uma chamada em andamento
+	stepExpire = "expire" // passa breaker.openTimeout
+)
+
+type breakerStep struct {
+	action string
+	want   BreakerState // estado depois do passo
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	consecutive := config.BreakerConfig{Failures: 3, OpenTimeout: time.Minute, HalfOpenProbes: 2}
+	rate := config.BreakerConfig{ErrorRate: 0.5, MinRequests: 4, Window: time.Minute, OpenTimeout: time.Minute, HalfOpenProbes: 1}
+	open := []breakerStep{{stepFail, BreakerClosed}, {stepFail, BreakerClosed}, {stepFail, BreakerOpen}}
+
+	tests := []struct {
+		name  string
+		cfg   config.BreakerConfig
+		steps []breakerStep
+	}{
+		{"falhas seguidas abrem", consecutive, append(open[:3:3],
+			breakerStep{stepDeny, BreakerOpen})},
+		{"sucesso zera as falhas seguidas", consecutive, []breakerStep{
+			{stepFail, BreakerClosed}, {stepFail, BreakerClosed}, {stepOK, BreakerClosed},
+			{stepFail, BreakerClosed}, {stepFail, BreakerClosed}}},
+		{"meio aberto fecha com todas as sondas", consecutive, append(open[:3:3],
+			breakerStep{stepExpire, BreakerOpen}, breakerStep{stepOK, BreakerHalfOpen}, breakerStep{stepOK, BreakerClosed},
+			// o ciclo recomeça do zero: duas falhas não abrem
+			breakerStep{stepFail, BreakerClosed}, breakerStep{stepFail, BreakerClosed})},
+		{"falha no meio aberto reabre", consecutive, append(open[:3:3],
+			breakerStep{stepExpire, BreakerOpen}, breakerStep{stepOK, BreakerHalfOpen}, breakerStep{stepFail, BreakerOpen},
+			breakerStep{stepDeny, BreakerOpen}, breakerStep{stepExpire, BreakerOpen}, breakerStep{stepOK, BreakerHalfOpen})},
+		{"meio aberto limita as sondas", consecutive, append(open[:3:3],
+			breakerStep{stepExpire, BreakerOpen}, breakerStep{stepAllow, BreakerHalfOpen}, breakerStep{stepAllow, BreakerHalfOpen},
+			breakerStep{stepDeny, BreakerHalfOpen})},
+		{"cancel devolve a sonda", consecutive, append(open[:3:3],
+			breakerStep{stepExpire, BreakerOpen}, breakerStep{stepAllow, BreakerHalfOpen}, breakerStep{stepAllow, BreakerHalfOpen},
+			breakerStep{stepCancel, BreakerHalfOpen}, breakerStep{stepAllow, BreakerHalfOpen}, breakerStep{stepDeny, BreakerHalfOpen})},
+		{"taxa de erro abaixo do mínimo de chamadas", rate, []breakerStep{
+			{stepFail, BreakerClosed}, {stepFail, BreakerClosed}, {stepFail, BreakerClosed}}},
+		{"taxa de erro abre", rate, []breakerStep{
+			{stepOK, BreakerClosed}, {stepFail, BreakerClosed}, {stepOK, BreakerClosed}, {stepFail, BreakerOpen},
+			{stepExpire, BreakerOpen}, {stepOK, BreakerClosed}}},
+		{"taxa de erro abaixo do limite", rate, []breakerStep{
+			{stepOK, BreakerClosed}, {stepOK, BreakerClosed}, {stepOK, BreakerClosed}, {stepFail, BreakerClosed},
+			{stepOK, BreakerClosed}, {stepFail, BreakerClosed}, {stepOK, BreakerClosed}}},
+		{"desligado", config.BreakerConfig{OpenTimeout: time.Minute}, []breakerStep{
+			{stepFail, BreakerClosed}, {stepFail, BreakerClosed}, {stepFail, BreakerClosed}, {stepFail, BreakerClosed}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker("test", tt.cfg)
+			for i, step := range tt.steps {
+				switch step.action {
+				case stepOK, stepFail:
+					if !b.Allow() {
+						t.Fatalf("passo %d (%s): Allow recusou", i, step.action)
+					}
+					b.Record(step.action == stepOK)
+				case stepAllow:
+					if !b.Allow() {
+						t.Fatalf("passo %d (%s): Allow recusou", i, step.action)
+					}
+				case stepDeny:
+					if b.Allow() {
+						t.Fatalf("passo %d (%s): Allow liberou", i, step.action)
+					}
+				case stepCancel:
+					b.Cancel()
+				case stepExpire:
+					b.mu.Lock()
+					b.openedAt = b.openedAt.Add(-tt.cfg.OpenTimeout)
+					b.mu.Unlock()
+				}
+				if got := b.State(); got != step.want {
+					t.Fatalf("passo %d (%s): estado %s, esperado %s", i, step.action, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerErrorRateWindow(t *testing.T) {
+	cfg := config.BreakerConfig{ErrorRate: 0.5, MinRequests: 4, Window: 10 * time.Second}
+	type calls struct {
+		at time.Duration // desde o início
+		ok bool
+		n  int
+	}
+	tests := []struct {
+		name  string
+		calls []calls
+		want  bool // a última chamada abre o breaker
+	}{
+		{"metade de falhas na janela", []calls{{0, true, 10}, {5 * time.Second, false, 10}}, true},
+		{"uma falha a menos", []calls{{0, true, 10}, {5 * time.Second, false, 9}}, false},
+		// os sucessos saíram da janela: sobram só as falhas
+		{"sucessos vencidos", []calls{{0, true, 10}, {11 * time.Second, false, 4}}, true},
+		// as falhas saíram da janela: sobram os sucessos
+		{"falhas vencidas", []calls{{0, false, 3}, {11 * time.Second, true, 4}, {11 * time.Second, false, 1}}, false},
+		// dentro da mesma janela as falhas antigas ainda contam
+		{"falhas no começo da janela", []calls{{0, false, 3}, {9 * time.Second, true, 4}, {9 * time.Second, false, 1}}, true},
+		{"abaixo do mínimo de chamadas", []calls{{0, false, 3}}, false},
+	}
+	start := time.Unix(1_700_000_000, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker("test", cfg)
+			var got bool
+			for _, c := range tt.calls {
+				for i := 0; i < c.n; i++ {
+					got = b.count(c.ok, start.Add(c.at))
+				}
+			}
+			if got != tt.want {
+				t.Fatalf("abre = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,13 +5,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"payment-proxy/internal/payments/entities"
+	"strings"
 	"time"
 )
 
 type PaymentGateway interface {
-	ProcessPayment(p entities.Payment) error
+	ProcessPayment(ctx context.Context, p entities.Payment) error
 	HealthCheck(ctx context.Context) (health bool, minResponseTime int)
 	GetType() entities.GatewayType
 }
@@ -29,20 +31,49 @@ type HealthCheckResponse struct {
 	MinResponseTime int  `json:"minResponseTime"`
 }
 
-func NewPaymentGateway(baseURL string, gatewayType entities.GatewayType) *PaymentsGateway {
+// StatusError é uma resposta do gateway diferente de 200
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("invalid response from gateway: %d %s", e.StatusCode, e.Body)
+}
+
+// Permanent indica um 4xx que vai se repetir num retry; 408 e 429 podem passar mais tarde
+func (e *StatusError) Permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 && !e.AlreadyProcessed() &&
+		e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
+// AlreadyProcessed indica que o gateway já tem um pagamento com esse correlationId: uma
+// tentativa anterior deu timeout do nosso lado mas foi aceita. O processador responde 422
+// ("correlationId already exists"); 409 é tratado do mesmo jeito.
+func (e *StatusError) AlreadyProcessed() bool {
+	if e.StatusCode == http.StatusConflict {
+		return true
+	}
+	return e.StatusCode == http.StatusUnprocessableEntity && strings.Contains(strings.ToLower(e.Body), "already")
+}
+
+// maior trecho do corpo de uma resposta de erro guardado em StatusError
+const maxErrorBody = 512
+
+func NewPaymentGateway(baseURL string, gatewayType entities.GatewayType, timeout time.Duration) *PaymentsGateway {
 	return &PaymentsGateway{
 		baseURL: baseURL,
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout: timeout,
 		},
 		gatewayType: gatewayType,
 	}
 }
 
-func (g *PaymentsGateway) ProcessPayment(p entities.Payment) error {
+func (g *PaymentsGateway) ProcessPayment(ctx context.Context, p entities.Payment) error {
 	payload, _ := json.Marshal(p)
 
-	req, err := http.NewRequest("POST", g.baseURL+"/payments", bytes.NewBuffer(payload))
+	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/payments", bytes.NewBuffer(payload))
 	if err != nil {
 		return err
 	}
@@ -54,15 +85,22 @@ func (g *PaymentsGateway) ProcessPayment(p entities.Payment) error {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		se := &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+		if se.AlreadyProcessed() {
+			// o pagamento está no gateway: conta como processado em vez de ir para o dead-letter
+			gatewayAlreadyProcessed.With(g.gatewayType.String()).Inc()
+			return nil
+		}
+		return se
+	}
+
 	var body map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
 		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("invalid response from gateway: %v", body)
-	}
-
 	return nil
 }
 
@@ -0,0 +1,149 @@
+package payment_processor
+
+import (
+	"context"
+	"payment-proxy/internal/config"
+	"sync"
+	"time"
+)
+
+// baselineWindow é de quanto em quanto tempo a latência mínima observada é renovada, para que
+// uma mudança permanente no gateway (mais lento ou mais rápido) vire a nova referência
+const baselineWindow = 10 * time.Second
+
+// AdaptiveLimiter limita as chamadas simultâneas a um gateway com AIMD guiado pela latência.
+// Cada sucesso com o limite em uso soma 1/limit (cerca de +1 a cada limit chamadas); uma falha
+// ou a latência média acima de limiter.tolerance vezes a mínima recente multiplica o limite por
+// limiter.backoff. Só chamadas que começaram depois da última redução podem reduzir de novo: as
+// que já estavam a caminho ainda refletem o limite anterior.
+type AdaptiveLimiter struct {
+	name string
+	cfg  config.LimiterConfig
+
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	waiters      []chan struct{}
+	smoothed     time.Duration // média móvel da latência
+	minRTT       time.Duration // menor latência da janela atual
+	prevMinRTT   time.Duration // menor latência da janela anterior
+	windowStart  time.Time
+	lastDecrease time.Time
+}
+
+func NewAdaptiveLimiter(name string, cfg config.LimiterConfig) *AdaptiveLimiter {
+	l := &AdaptiveLimiter{name: name, cfg: cfg, limit: float64(cfg.Initial), windowStart: time.Now()}
+	concurrencyLimit.WithFunc(func() float64 { return float64(l.Limit()) }, name)
+	gatewayInFlight.WithFunc(func() float64 { return float64(l.InFlight()) }, name)
+	return l
+}
+
+// Acquire espera uma vaga; cada Acquire sem erro precisa de um Release
+func (l *AdaptiveLimiter) Acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) && len(l.waiters) == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, w := range l.waiters {
+			if w == ready {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// a vaga chegou junto com o cancelamento: devolve para o próximo
+		l.inFlight--
+		l.wakeLocked()
+		return ctx.Err()
+	}
+}
+
+// Release devolve a vaga e ajusta o limite com a latência e o resultado da chamada
+func (l *AdaptiveLimiter) Release(rtt time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.adjustLocked(rtt, ok, time.Now())
+	l.wakeLocked()
+}
+
+// Cancel devolve a vaga de uma chamada interrompida do nosso lado, sem ajustar o limite
+func (l *AdaptiveLimiter) Cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.wakeLocked()
+}
+
+func (l *AdaptiveLimiter) adjustLocked(rtt time.Duration, ok bool, now time.Time) {
+	// chamadas que começaram antes da última redução refletem o limite anterior
+	fresh := !now.Add(-rtt).Before(l.lastDecrease)
+	if ok {
+		if now.Sub(l.windowStart) >= baselineWindow {
+			l.prevMinRTT, l.minRTT, l.windowStart = l.minRTT, 0, now
+		}
+		if l.minRTT == 0 || rtt < l.minRTT {
+			l.minRTT = rtt
+		}
+		if !fresh {
+			return
+		}
+		if l.smoothed == 0 {
+			l.smoothed = rtt
+		} else {
+			l.smoothed = (9*l.smoothed + rtt) / 10
+		}
+	}
+
+	baseline := l.minRTT
+	if l.prevMinRTT > 0 && l.prevMinRTT < baseline {
+		baseline = l.prevMinRTT
+	}
+	if !ok || float64(l.smoothed) > l.cfg.Tolerance*float64(baseline) {
+		if fresh {
+			l.limit = max(float64(l.cfg.Min), l.limit*l.cfg.Backoff)
+			l.lastDecrease = now
+			l.smoothed = 0 // a média recomeça com as chamadas feitas sob o novo limite
+		}
+		return
+	}
+	// só cresce quando o limite está de fato sendo usado
+	if l.inFlight+1 >= int(l.limit)/2 {
+		l.limit = min(float64(l.cfg.Max), l.limit+1/l.limit)
+	}
+}
+
+// wakeLocked libera quem espera enquanto houver vaga no limite atual
+func (l *AdaptiveLimiter) wakeLocked() {
+	for len(l.waiters) > 0 && l.inFlight < int(l.limit) {
+		ready := l.waiters[0]
+		l.waiters[0] = nil
+		l.waiters = l.waiters[1:]
+		l.inFlight++
+		close(ready)
+	}
+}
+
+// Limit retorna o limite atual de chamadas simultâneas
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+func (l *AdaptiveLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
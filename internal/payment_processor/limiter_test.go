@@ -0,0 +1,165 @@
+package payment_processor
+
+import (
+	"context"
+	"errors"
+	"payment-proxy/internal/config"
+	"testing"
+	"time"
+)
+
+var testLimiterConfig = config.LimiterConfig{Initial: 10, Min: 2, Max: 20, Backoff: 0.5, Tolerance: 2}
+
+type limiterStep struct {
+	at       time.Duration // fim da chamada, desde o início
+	rtt      time.Duration
+	ok       bool
+	inFlight int // outras chamadas em andamento quando esta termina
+	n        int // repetições, 1 ns mais tarde cada
+}
+
+func TestLimiterAIMD(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		cfg      config.LimiterConfig
+		steps    []limiterStep
+		min, max float64 // faixa esperada do limite no fim
+	}{
+		// +1/limit por sucesso: 20 sucessos somam perto de 2
+		{"cresce com o limite em uso", testLimiterConfig, []limiterStep{{100 * ms, 10 * ms, true, 9, 20}}, 11.5, 12},
+		{"não cresce sem uso", testLimiterConfig, []limiterStep{{100 * ms, 10 * ms, true, 0, 20}}, 10, 10},
+		{"não passa do máximo", config.LimiterConfig{Initial: 10, Min: 2, Max: 11, Backoff: 0.5, Tolerance: 2},
+			[]limiterStep{{100 * ms, 10 * ms, true, 20, 100}}, 11, 11},
+		{"falha reduz", testLimiterConfig, []limiterStep{{100 * ms, 10 * ms, false, 9, 1}}, 5, 5},
+		// as chamadas que já estavam a caminho refletem o limite anterior
+		{"falhas da mesma leva reduzem uma vez", testLimiterConfig, []limiterStep{
+			{100 * ms, 50 * ms, false, 9, 1}, {110 * ms, 50 * ms, false, 9, 5}}, 5, 5},
+		{"falha de chamada nova reduz de novo", testLimiterConfig, []limiterStep{
+			{100 * ms, 50 * ms, false, 9, 1}, {200 * ms, 50 * ms, false, 9, 1}}, 2.5, 2.5},
+		{"não passa do mínimo", testLimiterConfig, []limiterStep{
+			{100 * ms, 10 * ms, false, 0, 1}, {200 * ms, 10 * ms, false, 0, 1}, {300 * ms, 10 * ms, false, 0, 1},
+			{400 * ms, 10 * ms, false, 0, 1}}, 2, 2},
+		// a média passa de 2x a mínima na segunda chamada lenta: (9*19 + 100) / 10 = 27.1 ms
+		{"latência alta reduz", testLimiterConfig, []limiterStep{
+			{100 * ms, 10 * ms, true, 0, 5}, {300 * ms, 100 * ms, true, 0, 1}, {400 * ms, 100 * ms, true, 0, 1}}, 5, 5},
+		{"latência dentro da tolerância", testLimiterConfig, []limiterStep{
+			{100 * ms, 10 * ms, true, 0, 5}, {300 * ms, 19 * ms, true, 0, 50}}, 10, 10},
+		// uma chamada lenta iniciada antes da redução não reduz de novo
+		{"latência alta de antes da redução", testLimiterConfig, []limiterStep{
+			{100 * ms, 10 * ms, true, 0, 5}, {300 * ms, 10 * ms, false, 0, 1}, {350 * ms, 100 * ms, true, 0, 10}}, 5, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewAdaptiveLimiter("test", tt.cfg)
+			start := l.windowStart
+			for _, s := range tt.steps {
+				for i := 0; i < s.n; i++ {
+					l.inFlight = s.inFlight
+					l.adjustLocked(s.rtt, s.ok, start.Add(s.at+time.Duration(i)))
+				}
+			}
+			if l.limit < tt.min || l.limit > tt.max {
+				t.Fatalf("limite = %.2f, esperado entre %.2f e %.2f", l.limit, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+// acquired devolve um canal que recebe o resultado de Acquire
+func acquired(ctx context.Context, l *AdaptiveLimiter) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- l.Acquire(ctx) }()
+	return done
+}
+
+// waitQueue espera até n chamadas estarem na fila de Acquire
+func waitQueue(t *testing.T, l *AdaptiveLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		l.mu.Lock()
+		queued := len(l.waiters)
+		l.mu.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d chamadas na fila, esperado %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Acquire não retornou")
+		return nil
+	}
+}
+
+func TestLimiterAcquireCancelled(t *testing.T) {
+	l := NewAdaptiveLimiter("test", config.LimiterConfig{Initial: 1, Min: 1, Max: 1, Backoff: 0.5, Tolerance: 2})
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire com o limite cheio = %v, esperado DeadlineExceeded", err)
+	}
+	// quem desistiu saiu da fila e não recebe a vaga
+	waitQueue(t, l, 0)
+	l.Cancel()
+	if n := l.InFlight(); n != 0 {
+		t.Fatalf("InFlight = %d, esperado 0", n)
+	}
+}
+
+// A vaga é entregue a quem espera no mesmo instante em que o ctx dele é cancelado: ela não
+// pode se perder nem ficar com quem recebeu erro.
+func TestLimiterAcquireCancelledDuringHandover(t *testing.T) {
+	l := NewAdaptiveLimiter("test", config.LimiterConfig{Initial: 1, Min: 1, Max: 1, Backoff: 0.5, Tolerance: 2})
+	for i := 0; i < 500; i++ {
+		if err := l.Acquire(context.Background()); err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		first := acquired(ctx, l)
+		waitQueue(t, l, 1)
+		second := acquired(context.Background(), l)
+		waitQueue(t, l, 2)
+
+		// cancela e devolve a vaga sob o lock: as duas coisas chegam juntas para quem espera
+		l.mu.Lock()
+		cancel()
+		l.inFlight--
+		l.wakeLocked()
+		l.mu.Unlock()
+
+		if err := receive(t, first); err == nil {
+			// ficou com a vaga: o segundo só entra depois
+			if n := l.InFlight(); n != 1 {
+				t.Fatalf("rodada %d: InFlight = %d com o primeiro na vaga, esperado 1", i, n)
+			}
+			l.Cancel()
+		} else if !errors.Is(err, context.Canceled) {
+			t.Fatalf("rodada %d: Acquire = %v, esperado nil ou Canceled", i, err)
+		}
+		// de um jeito ou de outro a vaga chega ao segundo
+		if err := receive(t, second); err != nil {
+			t.Fatalf("rodada %d: segundo Acquire = %v", i, err)
+		}
+		if n := l.InFlight(); n != 1 {
+			t.Fatalf("rodada %d: InFlight = %d com o segundo na vaga, esperado 1", i, n)
+		}
+		l.Cancel()
+		if n := l.InFlight(); n != 0 {
+			t.Fatalf("rodada %d: InFlight = %d no fim, esperado 0", i, n)
+		}
+	}
+}
@@ -3,36 +3,40 @@ package payment_processor
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
+	"payment-proxy/internal/config"
 	"payment-proxy/internal/payments/entities"
 	"sync"
+	"time"
 )
 
 type GatewayManager struct {
 	gateways    map[entities.GatewayType]PaymentGateway
+	breakers    map[entities.GatewayType]*CircuitBreaker
+	limiters    map[entities.GatewayType]*AdaptiveLimiter
 	bestGateway entities.GatewayType
+	candidates  []entities.GatewayType // gateways saudáveis no último health check, o melhor primeiro
 	mu          sync.RWMutex
 }
 
-func NewGatewayManager() *GatewayManager {
-	gatewayDefaultUrl := os.Getenv("GATEWAY_DEFAULT_URL")
-	if gatewayDefaultUrl == "" {
-		log.Fatal("GATEWAY_DEFAULT_URL not defined")
-	}
-	gatewayDefault := NewPaymentGateway(gatewayDefaultUrl, entities.DefaultGateway)
-	gatewayFallbackUrl := os.Getenv("GATEWAY_FALLBACK_URL")
-	if gatewayFallbackUrl == "" {
-		log.Fatal("GATEWAY_FALLBACK_URL not defined")
-	}
-	gatewayFallback := NewPaymentGateway(gatewayFallbackUrl, entities.FallbackGateway)
+func NewGatewayManager(gatewayDefaultUrl, gatewayFallbackUrl string, timeout time.Duration, breaker config.BreakerConfig, limiter config.LimiterConfig) *GatewayManager {
+	gatewayDefault := NewPaymentGateway(gatewayDefaultUrl, entities.DefaultGateway, timeout)
+	gatewayFallback := NewPaymentGateway(gatewayFallbackUrl, entities.FallbackGateway, timeout)
 
 	gatewaysMap := make(map[entities.GatewayType]PaymentGateway)
 	gatewaysMap[entities.DefaultGateway] = gatewayDefault
 	gatewaysMap[entities.FallbackGateway] = gatewayFallback
 
+	breakers := make(map[entities.GatewayType]*CircuitBreaker, len(gatewaysMap))
+	limiters := make(map[entities.GatewayType]*AdaptiveLimiter, len(gatewaysMap))
+	for gwType := range gatewaysMap {
+		breakers[gwType] = NewCircuitBreaker(gwType.String(), breaker)
+		limiters[gwType] = NewAdaptiveLimiter(gwType.String(), limiter)
+	}
+
 	return &GatewayManager{
 		gateways:    gatewaysMap,
+		breakers:    breakers,
+		limiters:    limiters,
 		bestGateway: entities.GatewayType(-1), // Nenhum inicialmente
 	}
 }
@@ -41,7 +45,14 @@ func (m *GatewayManager) MonitorHealth() {
 	// Monitoramento simples sem lock distribuído, pois está tudo em memória
 
 	for _, gw := range m.gateways {
-		gw.HealthCheck(context.Background())
+		healthy, minResponseTime := gw.HealthCheck(context.Background())
+		label := gw.GetType().String()
+		if healthy {
+			gatewayHealthy.With(label).Set(1)
+		} else {
+			gatewayHealthy.With(label).Set(0)
+		}
+		gatewayMinResponseTime.With(label).Set(float64(minResponseTime) / 1000)
 	}
 
 	var theBest PaymentGateway
@@ -60,8 +71,26 @@ func (m *GatewayManager) MonitorHealth() {
 		theBest = m.gateways[entities.FallbackGateway]
 	}
 
+	// se o breaker do melhor estiver aberto, GetTheBest tenta o outro saudável
+	var candidates []entities.GatewayType
+	if theBest != nil {
+		candidates = append(candidates, theBest.GetType())
+		if other := m.gateways[entities.FallbackGateway]; theBest != other && fb.healthy {
+			candidates = append(candidates, other.GetType())
+		}
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.candidates = candidates
+
+	for gwType := range m.gateways {
+		if theBest != nil && theBest.GetType() == gwType {
+			gatewaySelected.With(gwType.String()).Set(1)
+		} else {
+			gatewaySelected.With(gwType.String()).Set(0)
+		}
+	}
 
 	if theBest == nil {
 		m.bestGateway = entities.GatewayType(-1)
@@ -72,18 +101,47 @@ func (m *GatewayManager) MonitorHealth() {
 	}
 }
 
+// GetTheBest retorna o melhor gateway saudável cujo circuit breaker deixa a chamada passar,
+// ou nil. Quem recebe um gateway precisa chamar Acquire e, se conseguir a vaga, Report (ou Cancel).
 func (m *GatewayManager) GetTheBest() PaymentGateway {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if m.bestGateway == entities.GatewayType(-1) {
-		return nil
+	for _, gwType := range m.candidates {
+		gateway, exists := m.gateways[gwType]
+		if !exists {
+			fmt.Printf("[ERROR] Gateway %d not found in the manager\n", gwType)
+			continue
+		}
+		if m.breakers[gwType].Allow() {
+			return gateway
+		}
+		breakerRejected.With(gwType.String()).Inc()
 	}
+	return nil
+}
 
-	gateway, exists := m.gateways[m.bestGateway]
-	if !exists {
-		fmt.Printf("[ERROR] Gateway %d not found in the manager\n", m.bestGateway)
-		return nil
+// Acquire espera uma vaga no limite de chamadas simultâneas de gw. Se ctx acabar antes,
+// a chamada liberada pelo circuit breaker é desistida e não precisa de Report.
+func (m *GatewayManager) Acquire(ctx context.Context, gw PaymentGateway) error {
+	err := m.limiters[gw.GetType()].Acquire(ctx)
+	if err != nil {
+		m.breakers[gw.GetType()].Cancel()
 	}
-	return gateway
+	return err
+}
+
+// Cancel devolve a vaga de Acquire de uma chamada cancelada pelo nosso ctx: ela não diz nada
+// sobre o gateway, então não alimenta o limite nem o circuit breaker
+func (m *GatewayManager) Cancel(gw PaymentGateway) {
+	m.limiters[gw.GetType()].Cancel()
+	m.breakers[gw.GetType()].Cancel()
+}
+
+// Report devolve a vaga de Acquire e alimenta o limite e o circuit breaker de gw com a latência
+// e o resultado da chamada. ok=false só para falhas do gateway (rede, timeout, 5xx, 408, 429);
+// um pagamento recusado é uma resposta válida.
+func (m *GatewayManager) Report(gw PaymentGateway, rtt time.Duration, ok bool) {
+	m.limiters[gw.GetType()].Release(rtt, ok)
+	m.breakers[gw.GetType()].Record(ok)
 }
@@ -0,0 +1,24 @@
+package payment_processor
+
+import "payment-proxy/internal/metrics"
+
+var (
+	gatewayHealthy = metrics.NewGaugeVec("payment_proxy_gateway_healthy",
+		"1 if the last health check reported the gateway as healthy.", "gateway")
+	gatewayMinResponseTime = metrics.NewGaugeVec("payment_proxy_gateway_min_response_time_seconds",
+		"minResponseTime reported by the last health check.", "gateway")
+	gatewaySelected = metrics.NewGaugeVec("payment_proxy_gateway_selected",
+		"1 for the gateway currently chosen by GetTheBest.", "gateway")
+	breakerState = metrics.NewGaugeVec("payment_proxy_gateway_breaker_state",
+		"Circuit breaker state of each gateway (0 closed, 1 open, 2 half-open).", "gateway")
+	breakerTransitions = metrics.NewCounterVec("payment_proxy_gateway_breaker_transitions_total",
+		"Circuit breaker state changes, by gateway and new state.", "gateway", "state")
+	concurrencyLimit = metrics.NewGaugeVec("payment_proxy_gateway_concurrency_limit",
+		"Current adaptive limit of concurrent calls to each gateway.", "gateway")
+	gatewayInFlight = metrics.NewGaugeVec("payment_proxy_gateway_in_flight_calls",
+		"Calls to each gateway currently in flight.", "gateway")
+	gatewayAlreadyProcessed = metrics.NewCounterVec("payment_proxy_gateway_already_processed_total",
+		"Payments the gateway answered as already received, counted as processed.", "gateway")
+	breakerRejected = metrics.NewCounterVec("payment_proxy_gateway_breaker_rejected_total",
+		"Times GetTheBest skipped a healthy gateway because its breaker did not allow the call.", "gateway")
+)
@@ -0,0 +1,97 @@
+package payments
+
+import (
+	"container/list"
+	"payment-proxy/internal/payments/entities"
+	"sync"
+	"time"
+)
+
+// DeadLetterStore guarda em memória os pagamentos que a fila desistiu de processar, até
+// serem reprocessados ou descartados. Cheio, descarta o mais antigo para abrir espaço.
+type DeadLetterStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // *entities.DeadLetter, do mais antigo para o mais novo
+}
+
+func NewDeadLetterStore(capacity int) *DeadLetterStore {
+	return &DeadLetterStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Add guarda dl, substituindo o que houver com o mesmo correlationId. Retorna o correlationId
+// descartado para abrir espaço, ou "" se nenhum foi.
+func (s *DeadLetterStore) Add(dl entities.DeadLetter) (evicted string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := dl.Payment.CorrelationID
+	if e, ok := s.entries[id]; ok {
+		s.order.Remove(e)
+	} else if s.order.Len() >= s.capacity {
+		oldest := s.order.Remove(s.order.Front()).(*entities.DeadLetter)
+		evicted = oldest.Payment.CorrelationID
+		delete(s.entries, evicted)
+	}
+	s.entries[id] = s.order.PushBack(&dl)
+	return evicted
+}
+
+// Get retorna uma cópia do pagamento guardado com correlationID
+func (s *DeadLetterStore) Get(correlationID string) (entities.DeadLetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[correlationID]
+	if !ok {
+		return entities.DeadLetter{}, false
+	}
+	return *e.Value.(*entities.DeadLetter), true
+}
+
+// Remove tira o pagamento da store e o retorna
+func (s *DeadLetterStore) Remove(correlationID string) (entities.DeadLetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[correlationID]
+	if !ok {
+		return entities.DeadLetter{}, false
+	}
+	delete(s.entries, correlationID)
+	return *s.order.Remove(e).(*entities.DeadLetter), true
+}
+
+// List retorna, do último guardado para o primeiro, até limit pagamentos com DeadAt entre from
+// (inclusive) e to (exclusive), e quantos existem no total nesse período. from e to podem ser nil.
+func (s *DeadLetterStore) List(from, to *time.Time, limit int) (total int, items []entities.DeadLetterSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for e := s.order.Back(); e != nil; e = e.Prev() {
+		dl := e.Value.(*entities.DeadLetter)
+		if (to != nil && !dl.DeadAt.Before(*to)) || (from != nil && dl.DeadAt.Before(*from)) {
+			continue
+		}
+		total++
+		if len(items) < limit {
+			items = append(items, dl.Summary())
+		}
+	}
+	return total, items
+}
+
+func (s *DeadLetterStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *DeadLetterStore) Purge() {
+	s.mu.Lock()
+	s.entries = make(map[string]*list.Element)
+	s.order.Init()
+	s.mu.Unlock()
+}
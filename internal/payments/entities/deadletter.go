@@ -0,0 +1,51 @@
+package entities
+
+import "time"
+
+type DeadLetterReason string
+
+const (
+	// DeadLetterMaxRetries: o pagamento falhou queue.maxRetries vezes
+	DeadLetterMaxRetries DeadLetterReason = "max_retries"
+	// DeadLetterPermanent: o erro nunca vai passar num retry (validação, 4xx do gateway)
+	DeadLetterPermanent DeadLetterReason = "permanent_error"
+)
+
+// Attempt é uma chamada a um gateway que falhou
+type Attempt struct {
+	At      time.Time `json:"at"`
+	Gateway string    `json:"gateway"`
+	Error   string    `json:"error"`
+}
+
+// DeadLetter é um pagamento que o worker desistiu de processar, com o motivo e as últimas tentativas
+type DeadLetter struct {
+	Key       string           `json:"key,omitempty"` // chave de idempotência, para o replay e a restauração
+	Payment   Payment          `json:"payment"`
+	Reason    DeadLetterReason `json:"reason"`
+	LastError string           `json:"lastError"`
+	Attempts  int              `json:"attempts"`
+	History   []Attempt        `json:"history"`
+	DeadAt    time.Time        `json:"deadAt"`
+}
+
+// DeadLetterSummary é o que a listagem mostra de cada DeadLetter
+type DeadLetterSummary struct {
+	CorrelationID string           `json:"correlationId"`
+	Amount        float64          `json:"amount"`
+	Reason        DeadLetterReason `json:"reason"`
+	LastError     string           `json:"lastError"`
+	Attempts      int              `json:"attempts"`
+	DeadAt        time.Time        `json:"deadAt"`
+}
+
+func (d *DeadLetter) Summary() DeadLetterSummary {
+	return DeadLetterSummary{
+		CorrelationID: d.Payment.CorrelationID,
+		Amount:        d.Payment.Amount,
+		Reason:        d.Reason,
+		LastError:     d.LastError,
+		Attempts:      d.Attempts,
+		DeadAt:        d.DeadAt,
+	}
+}
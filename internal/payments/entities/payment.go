@@ -9,6 +9,17 @@ const (
 	FallbackGateway
 )
 
+func (g GatewayType) String() string {
+	switch g {
+	case DefaultGateway:
+		return "default"
+	case FallbackGateway:
+		return "fallback"
+	default:
+		return "unknown"
+	}
+}
+
 type Payment struct {
 	CorrelationID      string      `json:"correlationId"`
 	Amount             float64     `json:"amount"`
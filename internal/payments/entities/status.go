@@ -0,0 +1,25 @@
+package entities
+
+import "time"
+
+type PaymentState string
+
+const (
+	StateReceived  PaymentState = "received"
+	StateQueued    PaymentState = "queued"
+	StateRetrying  PaymentState = "retrying"
+	StateProcessed PaymentState = "processed"
+	StateFailed    PaymentState = "failed"
+)
+
+// PaymentStatus descreve o ciclo de vida de um pagamento dentro do worker
+type PaymentStatus struct {
+	CorrelationID string       `json:"correlationId"`
+	State         PaymentState `json:"state"`
+	Gateway       string       `json:"gateway,omitempty"`
+	Attempts      int          `json:"attempts"`
+	LastError     string       `json:"lastError,omitempty"`
+	ReceivedAt    time.Time    `json:"receivedAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+	ProcessedAt   *time.Time   `json:"processedAt,omitempty"`
+}
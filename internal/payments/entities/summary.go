@@ -1,5 +1,7 @@
 package entities
 
+import "time"
+
 type Summary struct {
 	TotalRequests int     `json:"totalRequests"`
 	TotalAmount   float64 `json:"totalAmount"`
@@ -10,7 +12,21 @@ type AggregatedSummary struct {
 	Fallback Summary `json:"fallback"`
 }
 
+// Add soma o resumo de outro shard a s
+func (s *AggregatedSummary) Add(other AggregatedSummary) {
+	s.Default.TotalRequests += other.Default.TotalRequests
+	s.Default.TotalAmount += other.Default.TotalAmount
+	s.Fallback.TotalRequests += other.Fallback.TotalRequests
+	s.Fallback.TotalAmount += other.Fallback.TotalAmount
+}
+
 func (s *AggregatedSummary) RoundAmount() {
 	s.Default.TotalAmount = float64(int(s.Default.TotalAmount*100+0.5)) / 100
 	s.Fallback.TotalAmount = float64(int(s.Fallback.TotalAmount*100+0.5)) / 100
 }
+
+// SummaryBucket agrega os pagamentos de um intervalo que começa em Start
+type SummaryBucket struct {
+	Start time.Time `json:"start"`
+	AggregatedSummary
+}
@@ -2,9 +2,11 @@ package payments
 
 import (
 	"context"
+	"fmt"
 	"hash/fnv"
 	"payment-proxy/internal/payments/entities"
 	"runtime"
+	"sort"
 	"sync"
 	"time"
 )
@@ -115,6 +117,49 @@ func aggregateShard(s *paymentShard, acc entities.AggregatedSummary, from, to *t
 	return acc
 }
 
+// GetSeries agrupa os pagamentos em buckets de interval alinhados à época Unix.
+// Só retorna buckets com pagamentos, em ordem cronológica.
+func (db *InMemoryPaymentDB) GetSeries(ctx context.Context, from, to *time.Time, interval time.Duration) ([]entities.SummaryBucket, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid interval: %s", interval)
+	}
+
+	buckets := make(map[int64]*entities.SummaryBucket)
+	for _, shard := range db.shards {
+		shard.RLock()
+		for _, payment := range shard.store {
+			if from != nil && payment.RequestedAt.Before(*from) {
+				continue
+			}
+			if to != nil && payment.RequestedAt.After(*to) {
+				continue
+			}
+			start := payment.RequestedAt.Truncate(interval)
+			key := start.UnixNano()
+			b, ok := buckets[key]
+			if !ok {
+				b = &entities.SummaryBucket{Start: start.UTC()}
+				buckets[key] = b
+			}
+			if payment.PaymentGatewayType == entities.DefaultGateway {
+				b.Default.TotalAmount += payment.Amount
+				b.Default.TotalRequests++
+			} else {
+				b.Fallback.TotalAmount += payment.Amount
+				b.Fallback.TotalRequests++
+			}
+		}
+		shard.RUnlock()
+	}
+
+	series := make([]entities.SummaryBucket, 0, len(buckets))
+	for _, b := range buckets {
+		series = append(series, *b)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Start.Before(series[j].Start) })
+	return series, nil
+}
+
 // Purge sem realocação de mapa
 func (db *InMemoryPaymentDB) Purge(ctx context.Context) {
 	for _, shard := range db.shards {
@@ -132,6 +132,50 @@ func (r *PaymentPostgresRepository) GetByDateRange(ctx context.Context, from, to
 	return summary, nil
 }
 
+// GetSeries agrupa os pagamentos em buckets de interval (date_bin alinhado à época Unix)
+func (r *PaymentPostgresRepository) GetSeries(ctx context.Context, from, to *time.Time, interval time.Duration) ([]entities.SummaryBucket, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid interval: %s", interval)
+	}
+
+	query := `
+		SELECT
+			date_bin($3::interval, requested_at, TIMESTAMP '1970-01-01') AS bucket,
+			COUNT(*) FILTER (WHERE gateway_type = 0)  AS default_total_requests,
+			COALESCE(SUM(amount) FILTER (WHERE gateway_type = 0), 0) AS default_total_amount,
+			COUNT(*) FILTER (WHERE gateway_type = 1) AS fallback_total_requests,
+			COALESCE(SUM(amount) FILTER (WHERE gateway_type = 1), 0) AS fallback_total_amount
+		FROM payments
+		WHERE ($1::timestamptz IS NULL OR requested_at >= $1)
+		  AND ($2::timestamptz IS NULL OR requested_at <= $2)
+		GROUP BY bucket
+		ORDER BY bucket ASC
+	`
+
+	rows, err := r.pool.Query(ctx, query, from, to, interval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []entities.SummaryBucket
+	for rows.Next() {
+		var b entities.SummaryBucket
+		if err := rows.Scan(
+			&b.Start,
+			&b.Default.TotalRequests,
+			&b.Default.TotalAmount,
+			&b.Fallback.TotalRequests,
+			&b.Fallback.TotalAmount,
+		); err != nil {
+			return nil, err
+		}
+		b.Start = b.Start.UTC()
+		series = append(series, b)
+	}
+	return series, rows.Err()
+}
+
 func (r *PaymentPostgresRepository) Purge(ctx context.Context) {
 	_, err := r.pool.Exec(ctx, `DELETE FROM payments`)
 	if err != nil {
@@ -9,5 +9,6 @@ import (
 type Payment interface {
 	Save(cxt context.Context, payment *entities.Payment)
 	GetByDateRange(cxt context.Context, from, to *time.Time) (entities.AggregatedSummary, error)
+	GetSeries(cxt context.Context, from, to *time.Time, interval time.Duration) ([]entities.SummaryBucket, error)
 	Purge(ctx context.Context)
 }
@@ -9,6 +9,21 @@ import (
 	"time"
 )
 
+var (
+	ErrMissingCorrelationID = errors.New("correlation ID is required")
+	ErrInvalidAmount        = errors.New("invalid payment amount")
+	ErrNoGateway            = errors.New("no healthy gateways available")
+)
+
+// IsPermanent indica erros que nunca vão ter sucesso num retry: validação e 4xx do gateway
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrMissingCorrelationID) || errors.Is(err, ErrInvalidAmount) {
+		return true
+	}
+	var se *payment_processor.StatusError
+	return errors.As(err, &se) && se.Permanent()
+}
+
 type Service struct {
 	paymentRepository repository.Payment
 }
@@ -19,18 +34,18 @@ func NewPaymentService(repo repository.Payment) *Service {
 
 func (s *Service) ProcessPayment(ctx context.Context, gw payment_processor.PaymentGateway, payment entities.Payment) (entities.Payment, error) {
 	if payment.CorrelationID == "" {
-		return payment, errors.New("correlation ID is required")
+		return payment, ErrMissingCorrelationID
 	}
 
 	if payment.Amount <= 0 {
-		return payment, errors.New("invalid payment amount")
+		return payment, ErrInvalidAmount
 	}
 	payment.RequestedAt = time.Now().UTC()
 	if gw == nil {
-		return payment, errors.New("no healthy gateways available")
+		return payment, ErrNoGateway
 	}
 
-	err := gw.ProcessPayment(payment)
+	err := gw.ProcessPayment(ctx, payment)
 	if err != nil {
 		return payment, err
 	}
@@ -48,3 +63,7 @@ func (s *Service) GetPaymentsSummary(ctx context.Context, from, to *time.Time) (
 	}
 	return summary, nil
 }
+
+func (s *Service) GetPaymentsSeries(ctx context.Context, from, to *time.Time, interval time.Duration) ([]entities.SummaryBucket, error) {
+	return s.paymentRepository.GetSeries(ctx, from, to, interval)
+}
@@ -0,0 +1,155 @@
+package payments
+
+import (
+	"context"
+	"hash/fnv"
+	"payment-proxy/internal/payments/entities"
+	"sync"
+	"time"
+)
+
+type statusShard struct {
+	sync.RWMutex
+	store map[string]*entities.PaymentStatus
+}
+
+// StatusTracker guarda o estado de cada pagamento enquanto ele passa pela fila do worker e,
+// depois que termina (processed ou failed), por mais ttl
+type StatusTracker struct {
+	shards [shardCount]*statusShard
+	ttl    time.Duration
+}
+
+func NewStatusTracker(ttl time.Duration) *StatusTracker {
+	t := &StatusTracker{ttl: ttl}
+	for i := 0; i < shardCount; i++ {
+		t.shards[i] = &statusShard{
+			store: make(map[string]*entities.PaymentStatus),
+		}
+	}
+	return t
+}
+
+func (t *StatusTracker) getShard(key string) *statusShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return t.shards[h.Sum32()%shardCount]
+}
+
+// Received registra (ou reinicia) o ciclo de vida de um pagamento
+func (t *StatusTracker) Received(correlationID string) {
+	now := time.Now().UTC()
+	shard := t.getShard(correlationID)
+	shard.Lock()
+	shard.store[correlationID] = &entities.PaymentStatus{
+		CorrelationID: correlationID,
+		State:         entities.StateReceived,
+		ReceivedAt:    now,
+		UpdatedAt:     now,
+	}
+	shard.Unlock()
+}
+
+func (t *StatusTracker) Queued(correlationID string) {
+	t.update(correlationID, func(s *entities.PaymentStatus) {
+		s.State = entities.StateQueued
+	})
+}
+
+// Retrying registra uma tentativa que falhou e será refeita
+func (t *StatusTracker) Retrying(correlationID string, attempts int, err error) {
+	t.update(correlationID, func(s *entities.PaymentStatus) {
+		s.State = entities.StateRetrying
+		s.Attempts = attempts
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	})
+}
+
+func (t *StatusTracker) Processed(correlationID string, gateway entities.GatewayType, attempts int) {
+	t.update(correlationID, func(s *entities.PaymentStatus) {
+		s.State = entities.StateProcessed
+		s.Gateway = gateway.String()
+		s.Attempts = attempts
+		s.LastError = ""
+		processedAt := s.UpdatedAt
+		s.ProcessedAt = &processedAt
+	})
+}
+
+// Failed marca o pagamento como definitivamente não processado
+func (t *StatusTracker) Failed(correlationID string, attempts int, err error) {
+	t.update(correlationID, func(s *entities.PaymentStatus) {
+		s.State = entities.StateFailed
+		s.Attempts = attempts
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	})
+}
+
+// update aplica fn ao status existente; pagamentos desconhecidos (ex.: após purge) são ignorados
+func (t *StatusTracker) update(correlationID string, fn func(s *entities.PaymentStatus)) {
+	shard := t.getShard(correlationID)
+	shard.Lock()
+	if s, ok := shard.store[correlationID]; ok {
+		s.UpdatedAt = time.Now().UTC()
+		fn(s)
+	}
+	shard.Unlock()
+}
+
+// Get retorna uma cópia do status atual
+func (t *StatusTracker) Get(correlationID string) (entities.PaymentStatus, bool) {
+	shard := t.getShard(correlationID)
+	shard.RLock()
+	defer shard.RUnlock()
+	s, ok := shard.store[correlationID]
+	if !ok {
+		return entities.PaymentStatus{}, false
+	}
+	return *s, true
+}
+
+// Sweep remove os pagamentos que terminaram há mais de ttl e retorna quantos foram removidos.
+// Os que ainda estão na fila ficam, por mais que demorem.
+func (t *StatusTracker) Sweep(now time.Time) int {
+	removed := 0
+	for _, shard := range t.shards {
+		shard.Lock()
+		for k, s := range shard.store {
+			finished := s.State == entities.StateProcessed || s.State == entities.StateFailed
+			if finished && now.Sub(s.UpdatedAt) > t.ttl {
+				delete(shard.store, k)
+				removed++
+			}
+		}
+		shard.Unlock()
+	}
+	return removed
+}
+
+// Run executa Sweep periodicamente até ctx ser cancelado
+func (t *StatusTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.Sweep(now.UTC())
+		}
+	}
+}
+
+func (t *StatusTracker) Purge() {
+	for _, shard := range t.shards {
+		shard.Lock()
+		for k := range shard.store {
+			delete(shard.store, k)
+		}
+		shard.Unlock()
+	}
+}
@@ -0,0 +1,100 @@
+// Package ratelimit implementa token buckets por chave (API key ou IP),
+// em memória ou compartilhados entre instâncias via Redis.
+package ratelimit
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter decide se uma requisição identificada por key pode passar.
+// Quando não pode, retryAfter diz quando haverá token disponível.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (allowed bool, retryAfter time.Duration)
+}
+
+const shardCount = 64
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type shard struct {
+	sync.Mutex
+	buckets map[string]*bucket
+}
+
+// LocalLimiter mantém os buckets na memória do processo
+type LocalLimiter struct {
+	rate   float64 // tokens por segundo
+	burst  float64
+	shards [shardCount]*shard
+}
+
+func NewLocalLimiter(rate float64, burst int) *LocalLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &LocalLimiter{rate: rate, burst: float64(burst)}
+	for i := range l.shards {
+		l.shards[i] = &shard{buckets: make(map[string]*bucket)}
+	}
+	return l
+}
+
+func (l *LocalLimiter) getShard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return l.shards[h.Sum32()%shardCount]
+}
+
+func (l *LocalLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	now := time.Now()
+	s := l.getShard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	missing := 1 - b.tokens
+	return false, time.Duration(missing / l.rate * float64(time.Second))
+}
+
+// Run descarta periodicamente buckets cheios, que não guardam informação útil
+func (l *LocalLimiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// depois desse tempo parado qualquer bucket já estaria cheio de novo
+	idle := time.Duration(l.burst / l.rate * float64(time.Second))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, s := range l.shards {
+				s.Lock()
+				for k, b := range s.buckets {
+					if now.Sub(b.last) > idle {
+						delete(s.buckets, k)
+					}
+				}
+				s.Unlock()
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript faz refill e consumo de forma atômica no Redis.
+// KEYS[1] = bucket; ARGV = rate (tokens/s), burst, agora (ms).
+// Retorna {1, 0} se permitido ou {0, ms até o próximo token}.
+var tokenBucketScript = redis.NewScript(`
+local rate  = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now   = tonumber(ARGV[3])
+
+local state  = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts     = tonumber(state[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + (math.max(0, now - ts) / 1000) * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait}
+`)
+
+// RedisLimiter compartilha os buckets entre todas as instâncias da api.
+// Se o Redis falhar, usa o fallback local em vez de bloquear o tráfego.
+type RedisLimiter struct {
+	client   *redis.Client
+	prefix   string
+	rate     float64
+	burst    int
+	fallback *LocalLimiter
+	lastWarn atomic.Int64 // unix ns do último aviso de falha, para não inundar o log
+}
+
+func NewRedisLimiter(client *redis.Client, rate float64, burst int) *RedisLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RedisLimiter{
+		client:   client,
+		prefix:   "ratelimit:",
+		rate:     rate,
+		burst:    burst,
+		fallback: NewLocalLimiter(rate, burst),
+	}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (bool, time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	res, err := tokenBucketScript.Run(ctx, l.client, []string{l.prefix + key},
+		l.rate, l.burst, time.Now().UnixMilli()).Int64Slice()
+	if err != nil || len(res) != 2 {
+		if now := time.Now().UnixNano(); now-l.lastWarn.Load() > int64(10*time.Second) {
+			l.lastWarn.Store(now)
+			slog.Warn("redis rate limiter unavailable, using local buckets", "error", err)
+		}
+		return l.fallback.Allow(ctx, key)
+	}
+	if res[0] == 1 {
+		return true, 0
+	}
+	return false, time.Duration(math.Max(float64(res[1]), 1)) * time.Millisecond
+}
+
+// Run limpa os buckets do fallback local
+func (l *RedisLimiter) Run(ctx context.Context, interval time.Duration) {
+	l.fallback.Run(ctx, interval)
+}
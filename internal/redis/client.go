@@ -2,7 +2,6 @@ package redis
 
 import (
 	"log"
-	"os"
 
 	"github.com/go-redsync/redsync/v4"
 	redsync_redis "github.com/go-redsync/redsync/v4/redis/goredis/v9"
@@ -14,10 +13,9 @@ type Client struct {
 	Lock   *redsync.Redsync
 }
 
-func NewClient() *Client {
-	redisUrl := os.Getenv("REDIS_URL")
+func NewClient(redisUrl string) *Client {
 	if redisUrl == "" {
-		log.Fatal("CONN_STRING not defined")
+		log.Fatal("REDIS_URL not defined")
 	}
 	client := redis.NewClient(&redis.Options{
 		Addr:         redisUrl,
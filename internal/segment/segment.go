@@ -0,0 +1,334 @@
+// Package segment guarda registros em arquivos numerados (00000001.seg, 00000002.seg, ...) de um
+// diretório; é a base de internal/spool e internal/wal. Cada registro é gravado como:
+//
+//	bytes 0-3  tamanho do conteúdo, uint32 big-endian
+//	bytes 4-7  CRC-32 (IEEE) do conteúdo
+//	bytes 8-   conteúdo
+//
+// Aqui ficam o enquadramento, a rotação, o fsync e a varredura de recuperação, que trunca o
+// segmento no primeiro registro incompleto ou corrompido (uma escrita interrompida por queda do
+// processo). O que o conteúdo significa e quando um segmento pode ser apagado fica com quem usa.
+package segment
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderSize é o que cada registro ocupa além do conteúdo
+const HeaderSize = 8
+
+var ErrChecksum = errors.New("segment: checksum mismatch")
+
+// FsyncPolicy define quando os dados vão para o disco
+type FsyncPolicy string
+
+const (
+	FsyncAlways   FsyncPolicy = "always"   // fsync a cada escrita; nada confirmado se perde
+	FsyncInterval FsyncPolicy = "interval" // fsync periódico em Run; uma queda perde no máximo o último intervalo
+	FsyncNever    FsyncPolicy = "never"    // o sistema operacional decide; sobrevive ao processo, não à máquina
+)
+
+type Options struct {
+	SegmentBytes  int64 // tamanho a partir do qual um novo segmento é aberto
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration // usado com FsyncInterval
+}
+
+// AppendRecord acrescenta a buf o registro com o conteúdo payload
+func AppendRecord(buf, payload []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	return append(buf, payload...)
+}
+
+// ReadRecord lê um registro de r, que tem remaining bytes até o fim do segmento, e retorna o
+// conteúdo e os bytes consumidos
+func ReadRecord(r *bufio.Reader, remaining int64) ([]byte, int, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	n := binary.BigEndian.Uint32(header[:4])
+	// tamanho além do fim do segmento: cabeçalho corrompido ou registro pela metade. Conferido
+	// antes de alocar, senão um cabeçalho corrompido pede até 4 GB
+	if int64(n) > remaining-HeaderSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, ErrChecksum
+	}
+	return data, HeaderSize + int(n), nil
+}
+
+// Dir são os segmentos de um diretório, e o último deles aberto para escrita. Não tem lock
+// próprio: o dono chama os métodos com o mutex que passou para Open travado; só Run o trava.
+type Dir struct {
+	path string
+	ext  string
+	opts Options
+	mu   sync.Locker
+
+	segments []uint64         // segmentos em uso, em ordem
+	sizes    map[uint64]int64 // tamanho de cada segmento
+	w        *os.File         // último segmento, aberto para escrita
+	dirty    bool             // há escrita sem fsync (FsyncInterval)
+	closed   bool
+}
+
+// Open cria o diretório se preciso e retorna os segmentos que já existem nele, em ordem. Eles
+// só entram em uso depois de Recover; em seguida Create ou Resume abre o segmento de escrita.
+func Open(path, ext string, opts Options, mu sync.Locker) (*Dir, []uint64, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, nil, err
+	}
+	d := &Dir{path: path, ext: ext, opts: opts, mu: mu, sizes: make(map[uint64]int64)}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var found []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, ext) {
+			continue
+		}
+		seg, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		found = append(found, seg)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i] < found[j] })
+	return d, found, nil
+}
+
+// Recover lê os registros de seg a partir de start, chamando fn para cada um (fn retorna false
+// para tratar o registro como corrompido), trunca o segmento no fim do último válido e o coloca
+// em uso. Retorna quantos bytes foram descartados.
+func (d *Dir) Recover(seg uint64, start int64, fn func(payload []byte) bool) (truncated int64, err error) {
+	valid, size, err := d.Scan(seg, start, fn)
+	if err != nil {
+		return 0, err
+	}
+	if valid < size {
+		if err := os.Truncate(d.Path(seg), valid); err != nil {
+			return 0, err
+		}
+		truncated = size - valid
+	}
+	d.segments = append(d.segments, seg)
+	d.sizes[seg] = valid
+	return truncated, nil
+}
+
+// Scan chama fn para cada registro válido de seg a partir de start e retorna até onde eles vão
+// e o tamanho do arquivo
+func (d *Dir) Scan(seg uint64, start int64, fn func(payload []byte) bool) (valid, size int64, err error) {
+	f, err := os.Open(d.Path(seg))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size = st.Size()
+	if start > size {
+		// início além do fim: o segmento foi truncado depois de lido
+		return size, size, nil
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	r := bufio.NewReader(f)
+	valid = start
+	for {
+		payload, n, err := ReadRecord(r, size-valid)
+		if err != nil || !fn(payload) {
+			return valid, size, nil
+		}
+		valid += int64(n)
+	}
+}
+
+// Create abre seg vazio para escrita e o coloca em uso como o último segmento
+func (d *Dir) Create(seg uint64) error {
+	f, err := os.OpenFile(d.Path(seg), os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	d.w = f
+	d.segments = append(d.segments, seg)
+	d.sizes[seg] = 0
+	return syncDir(d.path)
+}
+
+// Resume abre para escrita o último segmento em uso, continuando do fim dele
+func (d *Dir) Resume() error {
+	f, err := os.OpenFile(d.Path(d.Last()), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	d.w = f
+	return nil
+}
+
+// Fits diz se n bytes cabem no segmento atual; um segmento vazio aceita qualquer tamanho
+func (d *Dir) Fits(n int64) bool {
+	size := d.sizes[d.Last()]
+	return size == 0 || size+n <= d.opts.SegmentBytes
+}
+
+// Rotate fecha o segmento atual e abre o próximo
+func (d *Dir) Rotate() error {
+	if d.opts.Fsync != FsyncNever {
+		d.w.Sync()
+	}
+	d.w.Close()
+	return d.Create(d.Last() + 1)
+}
+
+// Write grava buf no fim do segmento atual, tudo ou nada, e faz o fsync conforme a política
+func (d *Dir) Write(buf []byte) error {
+	last := d.Last()
+	if _, err := d.w.Write(buf); err != nil {
+		// escrita parcial: descarta o que entrou para não deixar um registro pela metade
+		d.w.Truncate(d.sizes[last])
+		return err
+	}
+	d.sizes[last] += int64(len(buf))
+	switch d.opts.Fsync {
+	case FsyncAlways:
+		return d.w.Sync()
+	case FsyncInterval:
+		d.dirty = true
+	}
+	return nil
+}
+
+// Remove apaga seg, que não pode ser o segmento de escrita
+func (d *Dir) Remove(seg uint64) {
+	os.Remove(d.Path(seg))
+	delete(d.sizes, seg)
+	for i, s := range d.segments {
+		if s == seg {
+			d.segments = append(d.segments[:i], d.segments[i+1:]...)
+			break
+		}
+	}
+}
+
+// Reset apaga todos os segmentos e recomeça num novo, com número maior que os anteriores
+func (d *Dir) Reset() error {
+	next := d.Last() + 1
+	d.w.Close()
+	for _, seg := range d.segments {
+		os.Remove(d.Path(seg))
+	}
+	d.segments = nil
+	d.sizes = make(map[uint64]int64)
+	return d.Create(next)
+}
+
+// Segments são os segmentos em uso, em ordem; o slice não deve ser alterado
+func (d *Dir) Segments() []uint64 {
+	return d.segments
+}
+
+// Last é o segmento de escrita (ou o último recuperado, antes de Create/Resume)
+func (d *Dir) Last() uint64 {
+	if len(d.segments) == 0 {
+		return 0
+	}
+	return d.segments[len(d.segments)-1]
+}
+
+// Next retorna o primeiro segmento em uso depois de seg
+func (d *Dir) Next(seg uint64) (uint64, bool) {
+	for _, other := range d.segments {
+		if other > seg {
+			return other, true
+		}
+	}
+	return 0, false
+}
+
+// Size é o tamanho de seg
+func (d *Dir) Size(seg uint64) int64 {
+	return d.sizes[seg]
+}
+
+// Total é a soma dos segmentos em uso
+func (d *Dir) Total() int64 {
+	var total int64
+	for _, size := range d.sizes {
+		total += size
+	}
+	return total
+}
+
+func (d *Dir) Path(seg uint64) string {
+	return filepath.Join(d.path, fmt.Sprintf("%08d%s", seg, d.ext))
+}
+
+// Run faz o fsync periódico com FsyncInterval até ctx ser cancelado
+func (d *Dir) Run(ctx context.Context) {
+	if d.opts.Fsync != FsyncInterval {
+		return
+	}
+	ticker := time.NewTicker(d.opts.FsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			if d.dirty && !d.closed {
+				d.w.Sync()
+				d.dirty = false
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// Close grava o que estiver pendente e fecha o segmento de escrita
+func (d *Dir) Close() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	if d.opts.Fsync != FsyncNever {
+		d.w.Sync()
+	}
+	return d.w.Close()
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
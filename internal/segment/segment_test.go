@@ -0,0 +1,189 @@
+package segment
+
+import (
+	"encoding/binary"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+var testRecords = [][]byte{[]byte("primeiro"), []byte("segundo registro"), []byte("terceiro")}
+
+func testSegment(records [][]byte) []byte {
+	var buf []byte
+	for _, r := range records {
+		buf = AppendRecord(buf, r)
+	}
+	return buf
+}
+
+func TestRecover(t *testing.T) {
+	full := testSegment(testRecords)
+	twoRecords := int64(len(testSegment(testRecords[:2])))
+	lastPayload := int(twoRecords) + HeaderSize
+
+	tests := []struct {
+		name      string
+		data      func() []byte
+		reject    string // conteúdo que fn trata como corrompido
+		want      int    // registros aceitos
+		truncated int64
+	}{
+		{"íntegro", func() []byte { return full }, "", 3, 0},
+		{"vazio", func() []byte { return nil }, "", 0, 0},
+		{"último registro pela metade", func() []byte { return full[:len(full)-3] }, "", 2, int64(len(full)) - 3 - twoRecords},
+		{"só parte do cabeçalho", func() []byte { return full[:twoRecords+5] }, "", 2, 5},
+		{"checksum errado", func() []byte {
+			data := append([]byte(nil), full...)
+			data[lastPayload] ^= 0xff
+			return data
+		}, "", 2, int64(len(full)) - twoRecords},
+		{"tamanho além do fim", func() []byte {
+			data := append([]byte(nil), full...)
+			binary.BigEndian.PutUint32(data[twoRecords:], 1<<31)
+			return data
+		}, "", 2, int64(len(full)) - twoRecords},
+		{"lixo depois do último", func() []byte { return append(append([]byte(nil), full...), 0, 0, 0) }, "", 3, 3},
+		{"rejeitado por fn", func() []byte { return full }, "segundo registro", 1, int64(len(full)) - int64(len(testSegment(testRecords[:1])))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var mu sync.Mutex
+			d, _, err := Open(dir, ".seg", Options{SegmentBytes: 1 << 20, Fsync: FsyncNever}, &mu)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			data := tt.data()
+			if err := os.WriteFile(d.Path(1), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			var got [][]byte
+			truncated, err := d.Recover(1, 0, func(p []byte) bool {
+				if string(p) == tt.reject {
+					return false
+				}
+				got = append(got, p)
+				return true
+			})
+			if err != nil {
+				t.Fatalf("Recover: %v", err)
+			}
+			if !reflect.DeepEqual(got, testRecords[:tt.want]) && !(tt.want == 0 && got == nil) {
+				t.Fatalf("registros = %q, esperado %q", got, testRecords[:tt.want])
+			}
+			if truncated != tt.truncated {
+				t.Fatalf("truncados = %d, esperado %d", truncated, tt.truncated)
+			}
+			valid := int64(len(data)) - tt.truncated
+			if st, err := os.Stat(d.Path(1)); err != nil || st.Size() != valid {
+				t.Fatalf("arquivo com %v bytes depois de Recover, esperado %d (%v)", st.Size(), valid, err)
+			}
+			if d.Size(1) != valid {
+				t.Fatalf("Size = %d, esperado %d", d.Size(1), valid)
+			}
+
+			// a escrita continua do ponto truncado e a próxima varredura lê tudo
+			if err := d.Resume(); err != nil {
+				t.Fatalf("Resume: %v", err)
+			}
+			if err := d.Write(AppendRecord(nil, []byte("depois"))); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			d.Close()
+			count := 0
+			end, size, err := d.Scan(1, 0, func([]byte) bool { count++; return true })
+			if err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if count != tt.want+1 || end != size {
+				t.Fatalf("Scan leu %d registros até %d de %d, esperado %d até o fim", count, end, size, tt.want+1)
+			}
+		})
+	}
+}
+
+func TestScanStartBeyondEnd(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	d, _, err := Open(dir, ".seg", Options{SegmentBytes: 1 << 20, Fsync: FsyncNever}, &mu)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data := testSegment(testRecords)
+	if err := os.WriteFile(d.Path(1), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	valid, size, err := d.Scan(1, int64(len(data))+10, func([]byte) bool {
+		t.Fatal("fn chamada com início além do fim")
+		return true
+	})
+	if err != nil || valid != size || size != int64(len(data)) {
+		t.Fatalf("Scan = %d, %d, %v; esperado %d, %d, nil", valid, size, err, len(data), len(data))
+	}
+}
+
+func TestOpenFindsSegments(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"00000003.seg", "00000001.seg", "00000010.seg", "cursor", "x.seg", "00000002.wal"} {
+		if err := os.WriteFile(dir+"/"+name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mu sync.Mutex
+	_, found, err := Open(dir, ".seg", Options{}, &mu)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if want := []uint64{1, 3, 10}; !reflect.DeepEqual(found, want) {
+		t.Fatalf("segmentos = %v, esperado %v", found, want)
+	}
+}
+
+func TestRotateAndFits(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	d, _, err := Open(dir, ".seg", Options{SegmentBytes: 32, Fsync: FsyncAlways}, &mu)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := d.Create(1); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	big := AppendRecord(nil, make([]byte, 40))
+	// segmento vazio aceita qualquer tamanho
+	if !d.Fits(int64(len(big))) {
+		t.Fatal("segmento vazio recusou o registro")
+	}
+	if err := d.Write(big); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if d.Fits(1) {
+		t.Fatal("segmento cheio aceitou mais um byte")
+	}
+	if err := d.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if err := d.Write(AppendRecord(nil, []byte("x"))); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got := d.Segments(); !reflect.DeepEqual(got, []uint64{1, 2}) {
+		t.Fatalf("segmentos = %v, esperado [1 2]", got)
+	}
+	if want := int64(len(big) + HeaderSize + 1); d.Total() != want {
+		t.Fatalf("Total = %d, esperado %d", d.Total(), want)
+	}
+	d.Remove(1)
+	if _, err := os.Stat(d.Path(1)); !os.IsNotExist(err) {
+		t.Fatalf("segmento 1 ainda existe: %v", err)
+	}
+	if err := d.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if got := d.Segments(); !reflect.DeepEqual(got, []uint64{3}) || d.Total() != 0 {
+		t.Fatalf("depois de Reset: segmentos = %v, total %d; esperado [3], 0", got, d.Total())
+	}
+	d.Close()
+}
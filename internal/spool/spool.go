@@ -0,0 +1,308 @@
+// Package spool é uma fila append-only em disco, com um único leitor.
+//
+// Os registros ficam em segmentos numerados (00000001.seg, 00000002.seg, ...) no diretório do
+// spool, com o enquadramento de internal/segment. O leitor lê com Peek e consome com Commit; a
+// posição confirmada fica no arquivo "cursor", trocado atomicamente (rename). Segmentos
+// inteiramente confirmados são apagados. Na abertura, a varredura de recuperação valida todos os
+// registros pendentes e trunca o segmento no primeiro registro incompleto ou corrompido.
+package spool
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"payment-proxy/internal/segment"
+	"sync"
+)
+
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+)
+
+var (
+	ErrFull   = errors.New("spool: size limit reached")
+	ErrClosed = errors.New("spool: closed")
+)
+
+// Options limita o spool
+type Options struct {
+	MaxBytes int64 // soma dos segmentos em disco; Append retorna ErrFull acima disso
+	segment.Options
+}
+
+// position é um ponto do spool entre dois registros
+type position struct {
+	segment uint64
+	offset  int64
+}
+
+// Recovery resume a varredura feita por Open
+type Recovery struct {
+	Segments       int   // segmentos com registros pendentes
+	Records        int   // registros pendentes
+	TruncatedBytes int64 // bytes descartados de registros incompletos ou corrompidos
+}
+
+// Spool é seguro para uso concorrente, mas Peek/Commit supõem um único leitor
+type Spool struct {
+	dir  string
+	opts Options
+
+	mu      sync.Mutex
+	segs    *segment.Dir
+	cursor  position
+	pending int
+	closed  bool
+}
+
+// Open abre (ou cria) o spool em dir e faz a varredura de recuperação
+func Open(dir string, opts Options) (*Spool, Recovery, error) {
+	var rec Recovery
+	s := &Spool{dir: dir, opts: opts}
+	segs, found, err := segment.Open(dir, segmentExt, opts.Options, &s.mu)
+	if err != nil {
+		return nil, rec, err
+	}
+	s.segs = segs
+	s.cursor, err = s.readCursor()
+	if err != nil {
+		return nil, rec, err
+	}
+
+	for _, seg := range found {
+		// segmento já confirmado que não chegou a ser apagado
+		if seg < s.cursor.segment {
+			os.Remove(segs.Path(seg))
+			continue
+		}
+		start := int64(0)
+		if seg == s.cursor.segment {
+			start = s.cursor.offset
+		}
+		records := 0
+		truncated, err := segs.Recover(seg, start, func([]byte) bool { records++; return true })
+		if err != nil {
+			return nil, rec, err
+		}
+		rec.TruncatedBytes += truncated
+		if records > 0 {
+			rec.Segments++
+		}
+		rec.Records += records
+	}
+	s.pending = rec.Records
+
+	// o cursor aponta para um segmento que não existe mais (ou para nenhum): recomeça no primeiro
+	if existing := segs.Segments(); len(existing) == 0 || s.cursor.segment < existing[0] {
+		first := uint64(1)
+		if len(existing) > 0 {
+			first = existing[0]
+		}
+		s.cursor = position{segment: first}
+	}
+
+	if len(segs.Segments()) == 0 {
+		err = segs.Create(s.cursor.segment)
+	} else {
+		err = segs.Resume()
+	}
+	if err != nil {
+		return nil, rec, err
+	}
+	return s, rec, nil
+}
+
+// Append grava os registros no fim do spool, todos ou nenhum
+func (s *Spool) Append(records ...[]byte) error {
+	var total int64
+	for _, r := range records {
+		total += int64(segment.HeaderSize + len(r))
+	}
+	buf := make([]byte, 0, total)
+	for _, r := range records {
+		buf = segment.AppendRecord(buf, r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.segs.Total()+total > s.opts.MaxBytes {
+		return ErrFull
+	}
+	if !s.segs.Fits(total) {
+		if err := s.segs.Rotate(); err != nil {
+			return err
+		}
+	}
+	if err := s.segs.Write(buf); err != nil {
+		return err
+	}
+	s.pending += len(records)
+	return nil
+}
+
+// Peek lê a partir do cursor até maxRecords registros ou maxBytes de conteúdo
+// (pelo menos um registro, se houver), sem consumi-los
+func (s *Spool) Peek(maxRecords, maxBytes int) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	var out [][]byte
+	total := 0
+	_, err := s.walkLocked(maxRecords, func(data []byte) bool {
+		if len(out) > 0 && total+len(data) > maxBytes {
+			return false
+		}
+		out = append(out, data)
+		total += len(data)
+		return true
+	})
+	return out, err
+}
+
+// Commit consome os n primeiros registros a partir do cursor
+func (s *Spool) Commit(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	count := 0
+	pos, err := s.walkLocked(n, func([]byte) bool { count++; return true })
+	if err != nil {
+		return err
+	}
+	s.cursor = pos
+	s.pending -= count
+
+	// apaga os segmentos que ficaram para trás
+	for segs := s.segs.Segments(); len(segs) > 1 && segs[0] < pos.segment; segs = s.segs.Segments() {
+		s.segs.Remove(segs[0])
+	}
+
+	// tudo consumido: começa um segmento novo para o atual não crescer para sempre
+	last := s.segs.Last()
+	if pos.segment == last && pos.offset >= s.segs.Size(last) && s.segs.Size(last) > 0 {
+		if err := s.segs.Rotate(); err != nil {
+			return err
+		}
+		s.segs.Remove(last)
+		s.cursor = position{segment: s.segs.Last()}
+	}
+	return s.writeCursorLocked()
+}
+
+// walkLocked percorre até max registros a partir do cursor, chamando fn para cada um;
+// fn retorna false para parar antes do registro. Retorna a posição depois do último aceito.
+func (s *Spool) walkLocked(max int, fn func(data []byte) bool) (position, error) {
+	pos := s.cursor
+	seen := 0
+	for seen < max {
+		end := s.segs.Size(pos.segment)
+		if pos.offset >= end {
+			next, ok := s.segs.Next(pos.segment)
+			if !ok {
+				break
+			}
+			pos = position{segment: next}
+			continue
+		}
+
+		f, err := os.Open(s.segs.Path(pos.segment))
+		if err != nil {
+			return s.cursor, err
+		}
+		if _, err := f.Seek(pos.offset, io.SeekStart); err != nil {
+			f.Close()
+			return s.cursor, err
+		}
+		// limita a leitura ao que já foi contabilizado: um Append pode estar em curso
+		r := bufio.NewReader(io.LimitReader(f, end-pos.offset))
+		for seen < max && pos.offset < end {
+			data, n, err := segment.ReadRecord(r, end-pos.offset)
+			if err != nil {
+				f.Close()
+				return s.cursor, fmt.Errorf("spool: segment %d offset %d: %w", pos.segment, pos.offset, err)
+			}
+			if !fn(data) {
+				f.Close()
+				return pos, nil
+			}
+			seen++
+			pos.offset += int64(n)
+		}
+		f.Close()
+	}
+	return pos, nil
+}
+
+// Pending é o número de registros ainda não confirmados
+func (s *Spool) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Size é o total em disco, contando a parte já confirmada do primeiro segmento
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.segs.Total()
+}
+
+// Run faz o fsync periódico com FsyncInterval até ctx ser cancelado
+func (s *Spool) Run(ctx context.Context) {
+	s.segs.Run(ctx)
+}
+
+// Close grava o que estiver pendente e fecha o spool
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.segs.Close()
+}
+
+// o cursor é "segmento offset" em texto; ausente significa o início do spool
+func (s *Spool) readCursor() (position, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return position{}, nil
+	}
+	if err != nil {
+		return position{}, err
+	}
+	var pos position
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pos.segment, &pos.offset); err != nil {
+		return position{}, fmt.Errorf("spool: invalid cursor file: %w", err)
+	}
+	return pos, nil
+}
+
+func (s *Spool) writeCursorLocked() error {
+	path := filepath.Join(s.dir, cursorFile)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "%d %d\n", s.cursor.segment, s.cursor.offset)
+	if s.opts.Fsync == segment.FsyncAlways {
+		f.Sync()
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,295 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"payment-proxy/internal/segment"
+	"reflect"
+	"testing"
+)
+
+func testOptions(segmentBytes, maxBytes int64) Options {
+	return Options{MaxBytes: maxBytes, Options: segment.Options{SegmentBytes: segmentBytes, Fsync: segment.FsyncNever}}
+}
+
+func testRecords(n int) [][]byte {
+	records := make([][]byte, n)
+	for i := range records {
+		records[i] = []byte(fmt.Sprintf("registro-%03d", i))
+	}
+	return records
+}
+
+func segmentFiles(t *testing.T, dir string) int {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestPeekCommit(t *testing.T) {
+	// cada registro ocupa 8 + 12 bytes
+	tests := []struct {
+		name         string
+		segmentBytes int64
+		records      int
+		steps        []int // registros lidos e confirmados em cada passo
+		reopen       bool  // reabre o spool entre os passos
+	}{
+		{"um segmento", 1 << 20, 10, []int{3, 3, 4}, false},
+		{"um registro por segmento", 20, 10, []int{3, 3, 4}, false},
+		{"atravessando segmentos", 50, 10, []int{1, 5, 2, 2}, false},
+		{"reabrindo entre os passos", 50, 10, []int{1, 5, 2, 2}, true},
+		{"tudo de uma vez", 50, 10, []int{10}, true},
+		{"pedindo mais que o pendente", 50, 5, []int{2, 10}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := testOptions(tt.segmentBytes, 1<<20)
+			s, _, err := Open(dir, opts)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			records := testRecords(tt.records)
+			for _, r := range records {
+				if err := s.Append(r); err != nil {
+					t.Fatalf("Append: %v", err)
+				}
+			}
+
+			next := 0
+			for _, n := range tt.steps {
+				if tt.reopen {
+					s.Close()
+					var rec Recovery
+					s, rec, err = Open(dir, opts)
+					if err != nil {
+						t.Fatalf("reabrindo: %v", err)
+					}
+					if rec.Records != len(records)-next || rec.TruncatedBytes != 0 {
+						t.Fatalf("recovery = %+v, esperado %d registros e nada truncado", rec, len(records)-next)
+					}
+				}
+				if s.Pending() != len(records)-next {
+					t.Fatalf("Pending = %d, esperado %d", s.Pending(), len(records)-next)
+				}
+				got, err := s.Peek(n, 1<<20)
+				if err != nil {
+					t.Fatalf("Peek: %v", err)
+				}
+				end := min(next+n, len(records))
+				if !reflect.DeepEqual(got, records[next:end]) {
+					t.Fatalf("Peek(%d) = %q, esperado %q", n, got, records[next:end])
+				}
+				// Peek não consome
+				if again, _ := s.Peek(n, 1<<20); !reflect.DeepEqual(again, got) {
+					t.Fatalf("segundo Peek = %q, esperado %q", again, got)
+				}
+				if err := s.Commit(len(got)); err != nil {
+					t.Fatalf("Commit: %v", err)
+				}
+				next = end
+			}
+
+			if s.Pending() != 0 {
+				t.Fatalf("Pending no fim = %d, esperado 0", s.Pending())
+			}
+			// tudo consumido: sobra só um segmento, vazio
+			if s.Size() != 0 || segmentFiles(t, dir) != 1 {
+				t.Fatalf("no fim: Size = %d com %d segmentos, esperado 0 com 1", s.Size(), segmentFiles(t, dir))
+			}
+			s.Close()
+		})
+	}
+}
+
+func TestCommitRemovesSegments(t *testing.T) {
+	dir := t.TempDir()
+	s, _, err := Open(dir, testOptions(20, 1<<20))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+	if err := s.Append(testRecords(5)...); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	// um Append com vários registros fica num segmento só
+	if err := s.Append(testRecords(3)...); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if n := segmentFiles(t, dir); n != 2 {
+		t.Fatalf("%d segmentos, esperado 2", n)
+	}
+	// o segmento só é apagado quando o cursor passa para o próximo
+	if err := s.Commit(5); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if n := segmentFiles(t, dir); n != 2 {
+		t.Fatalf("%d segmentos depois do Commit no fim do primeiro, esperado 2", n)
+	}
+	if err := s.Commit(1); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if n := segmentFiles(t, dir); n != 1 {
+		t.Fatalf("%d segmentos depois do Commit, esperado 1", n)
+	}
+	if s.Pending() != 2 || s.Size() != 60 {
+		t.Fatalf("Pending = %d, Size = %d; esperado 2, 60", s.Pending(), s.Size())
+	}
+}
+
+func TestPeekMaxBytes(t *testing.T) {
+	records := [][]byte{make([]byte, 100), []byte("a"), []byte("b"), make([]byte, 50)}
+	tests := []struct {
+		name     string
+		skip     int
+		maxBytes int
+		want     int
+	}{
+		// sempre pelo menos um registro, mesmo maior que o limite
+		{"primeiro maior que o limite", 0, 10, 1},
+		{"limite exato", 1, 2, 2},
+		{"para antes do que não cabe", 1, 40, 2},
+		{"tudo", 0, 1000, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, err := Open(t.TempDir(), testOptions(1<<20, 1<<20))
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer s.Close()
+			if err := s.Append(records...); err != nil {
+				t.Fatalf("Append: %v", err)
+			}
+			if err := s.Commit(tt.skip); err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+			got, err := s.Peek(10, tt.maxBytes)
+			if err != nil {
+				t.Fatalf("Peek: %v", err)
+			}
+			if !reflect.DeepEqual(got, records[tt.skip:tt.skip+tt.want]) {
+				t.Fatalf("Peek = %d registros, esperado %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	// cada registro ocupa 20 bytes; cabem 5, dois por segmento
+	dir := t.TempDir()
+	s, _, err := Open(dir, testOptions(40, 100))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close()
+	records := testRecords(8)
+
+	for _, r := range records[:4] {
+		if err := s.Append(r); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	// tudo ou nada: dois registros não cabem, nenhum entra
+	if err := s.Append(records[4:6]...); err != ErrFull {
+		t.Fatalf("Append acima do limite = %v, esperado ErrFull", err)
+	}
+	if s.Pending() != 4 || s.Size() != 80 {
+		t.Fatalf("depois do ErrFull: Pending = %d, Size = %d; esperado 4, 80", s.Pending(), s.Size())
+	}
+	if err := s.Append(records[4]); err != nil {
+		t.Fatalf("Append até o limite: %v", err)
+	}
+	if err := s.Append(records[5]); err != ErrFull {
+		t.Fatalf("Append com o spool cheio = %v, esperado ErrFull", err)
+	}
+
+	// confirmar libera espaço quando o segmento é apagado, ao passar para o próximo
+	if err := s.Commit(2); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if err := s.Append(records[5]); err != ErrFull {
+		t.Fatalf("Append com o primeiro segmento ainda no disco = %v, esperado ErrFull", err)
+	}
+	if err := s.Commit(1); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if err := s.Append(records[5:7]...); err != nil {
+		t.Fatalf("Append depois do Commit: %v", err)
+	}
+	got, err := s.Peek(10, 1<<20)
+	if err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+	if !reflect.DeepEqual(got, records[3:7]) {
+		t.Fatalf("Peek = %q, esperado %q", got, records[3:7])
+	}
+}
+
+func TestOpenTruncatesTail(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOptions(50, 1<<20)
+	s, _, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	records := testRecords(5)
+	if err := s.Append(records...); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := s.Commit(1); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	s.Close()
+
+	// escrita interrompida no meio do próximo registro
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	f, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(segment.AppendRecord(nil, []byte("registro-005"))[:10])
+	f.Close()
+
+	s, rec, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("reabrindo: %v", err)
+	}
+	defer s.Close()
+	if rec.Records != 4 || rec.TruncatedBytes != 10 {
+		t.Fatalf("recovery = %+v, esperado 4 registros e 10 bytes truncados", rec)
+	}
+	if err := s.Append(records[0]); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	got, err := s.Peek(10, 1<<20)
+	if err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+	want := append(append([][]byte(nil), records[1:]...), records[0])
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Peek = %q, esperado %q", got, want)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	s, _, err := Open(t.TempDir(), testOptions(1<<20, 1<<20))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	s.Close()
+	if err := s.Append([]byte("x")); err != ErrClosed {
+		t.Fatalf("Append depois de Close = %v, esperado ErrClosed", err)
+	}
+	if _, err := s.Peek(1, 1); err != ErrClosed {
+		t.Fatalf("Peek depois de Close = %v, esperado ErrClosed", err)
+	}
+	if err := s.Commit(1); err != ErrClosed {
+		t.Fatalf("Commit depois de Close = %v, esperado ErrClosed", err)
+	}
+}
@@ -0,0 +1,324 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Nos transportes stream cada mensagem vai num frame: tamanho em uint32 big-endian + conteúdo
+
+const writeTimeout = time.Second
+
+func writeFrame(c net.Conn, msg []byte) error {
+	frame := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+	c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.Write(frame)
+	return err
+}
+
+func readFrame(r *bufio.Reader, maxSize int) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if int(n) > maxSize {
+		// sem como ressincronizar o stream: a conexão precisa ser descartada
+		return nil, ErrMessageTooLarge
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func copyMessage(buf, msg []byte) (int, error) {
+	if len(msg) > len(buf) {
+		return 0, ErrMessageTooLarge
+	}
+	return copy(buf, msg), nil
+}
+
+// ----------- lado da api -----------
+
+// streamClient mantém um pool de conexões persistentes, discadas sob demanda e refeitas
+// quando caem. Send distribui as mensagens em round-robin; as respostas de todas as
+// conexões chegam num único canal lido por Recv.
+type streamClient struct {
+	network string
+	addr    string
+	maxSize int
+	conns   []*pooledConn
+	next    atomic.Uint32
+	msgs    chan []byte
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type pooledConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newStreamClient(network, addr string, opts Options) *streamClient {
+	size := opts.PoolSize
+	if size < 1 {
+		size = 1
+	}
+	c := &streamClient{
+		network: network,
+		addr:    addr,
+		maxSize: opts.MaxMessageSize,
+		conns:   make([]*pooledConn, size),
+		msgs:    make(chan []byte, 1024),
+		closed:  make(chan struct{}),
+	}
+	for i := range c.conns {
+		c.conns[i] = &pooledConn{}
+	}
+	return c
+}
+
+func (c *streamClient) Send(msg []byte) error {
+	pc := c.conns[int(c.next.Add(1))%len(c.conns)]
+	pc.mu.Lock()
+	if pc.conn == nil {
+		// disca sem o lock: com o worker fora do ar o dial leva até dialTimeout, e Close e o
+		// readLoop não podem ficar esperando por ele
+		pc.mu.Unlock()
+		if err := c.dial(pc); err != nil {
+			return err
+		}
+		pc.mu.Lock()
+	}
+	defer pc.mu.Unlock()
+	if pc.conn == nil {
+		// a conexão caiu logo depois de discada; a próxima mensagem disca de novo
+		return net.ErrClosed
+	}
+
+	if err := writeFrame(pc.conn, msg); err != nil {
+		// a próxima mensagem disca de novo
+		pc.conn.Close()
+		pc.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial abre uma conexão para pc; se outro Send abriu uma antes, fica com a dele
+func (c *streamClient) dial(pc *pooledConn) error {
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	default:
+	}
+	conn, err := net.DialTimeout(c.network, c.addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	select {
+	case <-c.closed:
+		// Close já passou por pc
+		conn.Close()
+		return net.ErrClosed
+	default:
+	}
+	if pc.conn != nil {
+		conn.Close()
+		return nil
+	}
+	pc.conn = conn
+	go c.readLoop(pc, conn)
+	return nil
+}
+
+func (c *streamClient) readLoop(pc *pooledConn, conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readFrame(r, c.maxSize)
+		if err != nil {
+			pc.mu.Lock()
+			if pc.conn == conn {
+				pc.conn = nil
+			}
+			pc.mu.Unlock()
+			conn.Close()
+			return
+		}
+		select {
+		case c.msgs <- msg:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *streamClient) Recv(buf []byte) (int, error) {
+	select {
+	case msg := <-c.msgs:
+		return copyMessage(buf, msg)
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *streamClient) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		for _, pc := range c.conns {
+			pc.mu.Lock()
+			if pc.conn != nil {
+				pc.conn.Close()
+				pc.conn = nil
+			}
+			pc.mu.Unlock()
+		}
+	})
+	return nil
+}
+
+// ----------- lado do worker -----------
+
+type streamMessage struct {
+	data []byte
+	peer *streamPeer
+}
+
+// streamListener aceita conexões e entrega as mensagens de todas elas por ReadFrom,
+// como se fosse um socket de datagramas
+type streamListener struct {
+	ln      net.Listener
+	maxSize int
+	msgs    chan streamMessage
+	closed  chan struct{}
+	once    sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func listenStream(network, addr string, opts Options) (Listener, error) {
+	if network == "unix" {
+		// socket de uma execução anterior que não terminou limpa
+		if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &streamListener{
+		ln:      ln,
+		maxSize: opts.MaxMessageSize,
+		msgs:    make(chan streamMessage, 1024),
+		closed:  make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+func (l *streamListener) acceptLoop() {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			select {
+			case <-l.closed:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		go l.serve(conn)
+	}
+}
+
+func (l *streamListener) serve(conn net.Conn) {
+	defer conn.Close()
+	peer := &streamPeer{conn: conn}
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readFrame(r, l.maxSize)
+		if err != nil {
+			return
+		}
+		select {
+		case l.msgs <- streamMessage{data: msg, peer: peer}:
+		case <-l.closed:
+			return
+		}
+	}
+}
+
+func (l *streamListener) ReadFrom(buf []byte) (int, Peer, error) {
+	l.mu.Lock()
+	deadline := l.deadline
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case m := <-l.msgs:
+		n, err := copyMessage(buf, m.data)
+		return n, m.peer, err
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	case <-l.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (l *streamListener) SetReadDeadline(t time.Time) error {
+	l.mu.Lock()
+	l.deadline = t
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *streamListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		err = l.ln.Close()
+	})
+	return err
+}
+
+// streamPeer responde pela mesma conexão em que a mensagem chegou
+type streamPeer struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (p *streamPeer) Send(msg []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return writeFrame(p.conn, msg)
+}
+
+func (p *streamPeer) String() string {
+	return p.conn.RemoteAddr().String()
+}
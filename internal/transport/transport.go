@@ -0,0 +1,85 @@
+// Package transport abstrai o canal de mensagens entre api e worker.
+//
+// Transportes disponíveis (config worker.transport, igual nos dois lados):
+//
+//   - udp: datagramas UDP (padrão). Sem garantia de entrega nem de ordem; cada mensagem cabe
+//     num datagrama de até worker.maxPacketSize. Perdas são cobertas pelo ack/retransmissão dos inserts.
+//   - unixgram: datagramas num socket Unix em volume compartilhado. O kernel não descarta nem
+//     reordena mensagens entre dois sockets; com a fila do destino cheia o envio espera vaga por
+//     até 100ms e falha com ErrSendTimeout, e a retransmissão cobre. Só funciona com api e
+//     worker no mesmo host.
+//   - unix: stream Unix com frames prefixados pelo tamanho. Entrega em ordem enquanto a conexão
+//     existir; mensagens em trânsito numa conexão que cai são perdidas e retransmitidas.
+//   - tcp: como unix, sobre um pool de conexões TCP persistentes (server.poolSize). A ordem só é
+//     garantida dentro de cada conexão; entre conexões do pool as mensagens podem se reordenar.
+//
+// Em todos os transportes uma mensagem é entregue inteira ou não é entregue.
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrMessageTooLarge = errors.New("transport: message too large")
+
+// Conn é o lado da api: envia requisições e recebe respostas de um worker.
+// Recv deve ter um único leitor; Send pode ser chamado concorrentemente.
+type Conn interface {
+	Send(msg []byte) error
+	// Recv copia a próxima mensagem para buf. Depois de Close retorna net.ErrClosed.
+	Recv(buf []byte) (int, error)
+	Close() error
+}
+
+// Peer é quem enviou uma mensagem ao worker; as respostas voltam por ele
+type Peer interface {
+	Send(msg []byte) error
+	String() string
+}
+
+// Listener é o lado do worker
+type Listener interface {
+	// ReadFrom copia a próxima mensagem para buf. Passado o deadline retorna
+	// um erro que satisfaz errors.Is(err, os.ErrDeadlineExceeded).
+	ReadFrom(buf []byte) (int, Peer, error)
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// Options ajusta os transportes
+type Options struct {
+	MaxMessageSize int // maior mensagem aceita nos transportes stream
+	PoolSize       int // conexões persistentes do tcp/unix no lado da api
+}
+
+const dialTimeout = time.Second
+
+// Dial conecta a api ao worker em addr (host:port ou caminho do socket)
+func Dial(kind, addr string, opts Options) (Conn, error) {
+	switch kind {
+	case "udp":
+		return dialUDP(addr)
+	case "unixgram":
+		return dialUnixgram(addr)
+	case "unix", "tcp":
+		return newStreamClient(kind, addr, opts), nil
+	default:
+		return nil, fmt.Errorf("transport: unknown transport %q", kind)
+	}
+}
+
+// Listen abre o worker em addr
+func Listen(kind, addr string, opts Options) (Listener, error) {
+	switch kind {
+	case "udp":
+		return listenUDP(addr)
+	case "unixgram":
+		return listenUnixgram(addr)
+	case "unix", "tcp":
+		return listenStream(kind, addr, opts)
+	default:
+		return nil, fmt.Errorf("transport: unknown transport %q", kind)
+	}
+}
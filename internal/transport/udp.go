@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"net"
+	"time"
+)
+
+type udpConn struct {
+	conn *net.UDPConn
+}
+
+func dialUDP(addr string) (Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpConn{conn: conn}, nil
+}
+
+func (c *udpConn) Send(msg []byte) error {
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+func (c *udpConn) Recv(buf []byte) (int, error) {
+	return c.conn.Read(buf)
+}
+
+func (c *udpConn) Close() error {
+	return c.conn.Close()
+}
+
+type udpListener struct {
+	conn *net.UDPConn
+}
+
+func listenUDP(addr string) (Listener, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpListener{conn: conn}, nil
+}
+
+func (l *udpListener) ReadFrom(buf []byte) (int, Peer, error) {
+	n, addr, err := l.conn.ReadFromUDP(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, udpPeer{conn: l.conn, addr: addr}, nil
+}
+
+func (l *udpListener) SetReadDeadline(t time.Time) error {
+	return l.conn.SetReadDeadline(t)
+}
+
+func (l *udpListener) Close() error {
+	return l.conn.Close()
+}
+
+type udpPeer struct {
+	conn *net.UDPConn
+	addr *net.UDPAddr
+}
+
+func (p udpPeer) Send(msg []byte) error {
+	_, err := p.conn.WriteToUDP(msg, p.addr)
+	return err
+}
+
+func (p udpPeer) String() string {
+	return p.addr.String()
+}
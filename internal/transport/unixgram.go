@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// datagramWriteTimeout limita quanto um envio espera vaga na fila do socket de destino. Com a
+// fila cheia o kernel não descarta: o envio bloqueia até o outro lado ler, e sem prazo um api
+// parado travaria o loop de leitura do worker (e vice-versa).
+const datagramWriteTimeout = 100 * time.Millisecond
+
+// ErrSendTimeout indica que a fila do destino continuou cheia até o fim do prazo de envio
+var ErrSendTimeout = errors.New("transport: send timed out, receiver queue full")
+
+// writeUnixgram envia msg a addr com prazo, trocando o erro de prazo por ErrSendTimeout
+func writeUnixgram(conn *net.UnixConn, msg []byte, addr *net.UnixAddr) error {
+	conn.SetWriteDeadline(time.Now().Add(datagramWriteTimeout))
+	_, err := conn.WriteToUnix(msg, addr)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return ErrSendTimeout
+	}
+	return err
+}
+
+// unixgramConn precisa de um caminho próprio para receber as respostas;
+// usa o caminho do worker com um sufixo aleatório, removido no Close
+type unixgramConn struct {
+	conn  *net.UnixConn
+	raddr *net.UnixAddr
+	local string
+}
+
+func dialUnixgram(addr string) (Conn, error) {
+	var b [6]byte
+	rand.Read(b[:])
+	local := addr + "." + hex.EncodeToString(b[:])
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: local, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &unixgramConn{
+		conn:  conn,
+		raddr: &net.UnixAddr{Name: addr, Net: "unixgram"},
+		local: local,
+	}, nil
+}
+
+func (c *unixgramConn) Send(msg []byte) error {
+	return writeUnixgram(c.conn, msg, c.raddr)
+}
+
+func (c *unixgramConn) Recv(buf []byte) (int, error) {
+	n, _, err := c.conn.ReadFromUnix(buf)
+	return n, err
+}
+
+func (c *unixgramConn) Close() error {
+	err := c.conn.Close()
+	os.Remove(c.local)
+	return err
+}
+
+type unixgramListener struct {
+	conn *net.UnixConn
+	path string
+}
+
+func listenUnixgram(addr string) (Listener, error) {
+	// socket de uma execução anterior que não terminou limpa
+	if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &unixgramListener{conn: conn, path: addr}, nil
+}
+
+func (l *unixgramListener) ReadFrom(buf []byte) (int, Peer, error) {
+	n, addr, err := l.conn.ReadFromUnix(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, unixgramPeer{conn: l.conn, addr: addr}, nil
+}
+
+func (l *unixgramListener) SetReadDeadline(t time.Time) error {
+	return l.conn.SetReadDeadline(t)
+}
+
+func (l *unixgramListener) Close() error {
+	err := l.conn.Close()
+	os.Remove(l.path)
+	return err
+}
+
+type unixgramPeer struct {
+	conn *net.UnixConn
+	addr *net.UnixAddr // nil se o remetente não tem caminho próprio
+}
+
+var errUnboundPeer = errors.New("transport: unixgram sender has no address to reply to")
+
+func (p unixgramPeer) Send(msg []byte) error {
+	if p.addr == nil {
+		return errUnboundPeer
+	}
+	return writeUnixgram(p.conn, msg, p.addr)
+}
+
+func (p unixgramPeer) String() string {
+	if p.addr == nil {
+		return "unixgram:unbound"
+	}
+	return p.addr.Name
+}
@@ -0,0 +1,294 @@
+// Package wal é um log de escrita antecipada de entradas identificadas por chave.
+//
+// Put grava (ou substitui) a entrada de uma chave e Checkpoint a encerra; na abertura, Open
+// devolve as entradas sem checkpoint, na ordem do último Put de cada uma. Os registros ficam em
+// segmentos numerados (00000001.wal, 00000002.wal, ...) com o enquadramento de internal/segment;
+// o conteúdo de cada um é a operação ('P' ou 'C'), o tamanho da chave (uvarint), a chave e os dados.
+//
+// Um segmento é apagado quando ele e todos os anteriores não têm mais nenhuma entrada viva (cujo
+// último Put esteja nele). Uma entrada que demora a terminar seguraria todos os segmentos
+// seguintes, então quando há mais de compactSegments segmentos as entradas vivas do mais antigo
+// são regravadas no atual e ele é apagado. Na abertura cada segmento é truncado no primeiro
+// registro incompleto ou corrompido (uma escrita interrompida por queda do processo).
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"payment-proxy/internal/segment"
+	"sort"
+	"sync"
+)
+
+const (
+	segmentExt = ".wal"
+
+	opPut        byte = 'P'
+	opCheckpoint byte = 'C'
+
+	// a partir de quantos segmentos o mais antigo é compactado
+	compactSegments = 8
+)
+
+var ErrClosed = errors.New("wal: closed")
+
+// Entry é uma entrada sem checkpoint encontrada por Open
+type Entry struct {
+	Key  string
+	Data []byte
+}
+
+// Recovery resume a varredura feita por Open
+type Recovery struct {
+	Segments       int   // segmentos lidos
+	Entries        int   // entradas sem checkpoint
+	TruncatedBytes int64 // bytes descartados de registros incompletos ou corrompidos
+}
+
+// Log é seguro para uso concorrente
+type Log struct {
+	mu     sync.Mutex
+	segs   *segment.Dir
+	live   map[uint64]int    // entradas vivas cujo último Put está em cada segmento
+	latest map[string]uint64 // segmento do último Put de cada chave viva
+	closed bool
+}
+
+// Open abre (ou cria) o log em dir e devolve as entradas sem checkpoint
+func Open(dir string, opts segment.Options) (*Log, []Entry, Recovery, error) {
+	var rec Recovery
+	l := &Log{
+		live:   make(map[uint64]int),
+		latest: make(map[string]uint64),
+	}
+	segs, found, err := segment.Open(dir, segmentExt, opts, &l.mu)
+	if err != nil {
+		return nil, nil, rec, err
+	}
+	l.segs = segs
+
+	// último Put de cada chave viva, com a ordem em que foi lido
+	type foundEntry struct {
+		seq  int
+		data []byte
+	}
+	entries := make(map[string]foundEntry)
+	seq := 0
+	for _, seg := range found {
+		truncated, err := segs.Recover(seg, 0, func(payload []byte) bool {
+			op, key, data, err := decodePayload(payload)
+			if err != nil {
+				return false
+			}
+			switch op {
+			case opPut:
+				if prev, ok := l.latest[key]; ok {
+					l.live[prev]--
+				}
+				l.latest[key] = seg
+				l.live[seg]++
+				entries[key] = foundEntry{seq: seq, data: data}
+				seq++
+			case opCheckpoint:
+				if prev, ok := l.latest[key]; ok {
+					l.live[prev]--
+					delete(l.latest, key)
+					delete(entries, key)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, nil, rec, err
+		}
+		rec.TruncatedBytes += truncated
+		rec.Segments++
+	}
+
+	out := make([]Entry, 0, len(entries))
+	for key, e := range entries {
+		out = append(out, Entry{Key: key, Data: e.data})
+	}
+	sort.Slice(out, func(i, j int) bool { return entries[out[i].Key].seq < entries[out[j].Key].seq })
+	rec.Entries = len(out)
+
+	// sempre começa um segmento novo: o último pode ter sido truncado no meio
+	if err := segs.Create(segs.Last() + 1); err != nil {
+		return nil, nil, rec, err
+	}
+	l.collectLocked()
+	return l, out, rec, nil
+}
+
+func decodePayload(p []byte) (op byte, key string, data []byte, err error) {
+	if len(p) < 2 || (p[0] != opPut && p[0] != opCheckpoint) {
+		return 0, "", nil, fmt.Errorf("wal: invalid record")
+	}
+	n, w := binary.Uvarint(p[1:])
+	if w <= 0 || uint64(len(p)-1-w) < n {
+		return 0, "", nil, fmt.Errorf("wal: invalid record")
+	}
+	start := 1 + w
+	return p[0], string(p[start : start+int(n)]), p[start+int(n):], nil
+}
+
+func appendRecord(buf []byte, op byte, key string, data []byte) []byte {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64+len(key)+len(data))
+	payload = append(payload, op)
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, data...)
+	return segment.AppendRecord(buf, payload)
+}
+
+// Put grava data como a entrada atual de key
+func (l *Log) Put(key string, data []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+
+	rec := appendRecord(nil, opPut, key, data)
+	if !l.segs.Fits(int64(len(rec))) {
+		if err := l.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	if err := l.segs.Write(rec); err != nil {
+		return err
+	}
+	l.moveLocked(key)
+	l.collectLocked()
+	return nil
+}
+
+// Checkpoint encerra a entrada de key; chaves sem entrada são ignoradas
+func (l *Log) Checkpoint(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	prev, ok := l.latest[key]
+	if !ok {
+		return nil
+	}
+
+	if err := l.segs.Write(appendRecord(nil, opCheckpoint, key, nil)); err != nil {
+		return err
+	}
+	l.live[prev]--
+	delete(l.latest, key)
+	l.collectLocked()
+	return nil
+}
+
+// Reset descarta todas as entradas
+func (l *Log) Reset() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	l.live = make(map[uint64]int)
+	l.latest = make(map[string]uint64)
+	return l.segs.Reset()
+}
+
+// Live é o número de entradas sem checkpoint
+func (l *Log) Live() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.latest)
+}
+
+// Size é o total em disco
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.segs.Total()
+}
+
+// Run faz o fsync periódico com FsyncInterval até ctx ser cancelado
+func (l *Log) Run(ctx context.Context) {
+	l.segs.Run(ctx)
+}
+
+// Close grava o que estiver pendente e fecha o log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	return l.segs.Close()
+}
+
+// moveLocked registra que o último Put de key está no segmento atual
+func (l *Log) moveLocked(key string) {
+	if prev, ok := l.latest[key]; ok {
+		l.live[prev]--
+	}
+	last := l.segs.Last()
+	l.latest[key] = last
+	l.live[last]++
+}
+
+// collectLocked apaga os segmentos do início que não têm mais entradas vivas
+func (l *Log) collectLocked() {
+	for segs := l.segs.Segments(); len(segs) > 1 && l.live[segs[0]] == 0; segs = l.segs.Segments() {
+		delete(l.live, segs[0])
+		l.segs.Remove(segs[0])
+	}
+}
+
+func (l *Log) rotateLocked() error {
+	if err := l.segs.Rotate(); err != nil {
+		return err
+	}
+	if len(l.segs.Segments()) > compactSegments {
+		return l.compactLocked()
+	}
+	return nil
+}
+
+// compactLocked regrava no segmento atual as entradas vivas do mais antigo, que então é apagado
+func (l *Log) compactLocked() error {
+	head := l.segs.Segments()[0]
+	if l.live[head] == 0 {
+		return nil
+	}
+	// só o último Put de cada chave no segmento vale
+	data := make(map[string][]byte)
+	var keys []string
+	_, _, err := l.segs.Scan(head, 0, func(payload []byte) bool {
+		op, key, d, err := decodePayload(payload)
+		if err != nil {
+			return false
+		}
+		if op != opPut || l.latest[key] != head {
+			return true
+		}
+		if _, seen := data[key]; !seen {
+			keys = append(keys, key)
+		}
+		data[key] = d
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	for _, key := range keys {
+		buf = appendRecord(buf, opPut, key, data[key])
+	}
+	if err := l.segs.Write(buf); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		l.moveLocked(key)
+	}
+	l.collectLocked()
+	return nil
+}
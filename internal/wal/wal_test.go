@@ -0,0 +1,204 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"payment-proxy/internal/segment"
+	"reflect"
+	"testing"
+)
+
+var testOptions = segment.Options{SegmentBytes: 1 << 20, Fsync: segment.FsyncNever}
+
+// op é um Put (data != nil), um Checkpoint (data == nil) ou um Reset (key == "")
+type op struct {
+	key  string
+	data []byte
+}
+
+func put(key, data string) op  { return op{key, []byte(data)} }
+func checkpoint(key string) op { return op{key: key} }
+
+func apply(t *testing.T, l *Log, ops []op) {
+	t.Helper()
+	for _, o := range ops {
+		var err error
+		switch {
+		case o.key == "":
+			err = l.Reset()
+		case o.data == nil:
+			err = l.Checkpoint(o.key)
+		default:
+			err = l.Put(o.key, o.data)
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", o.key, err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []op
+		want []Entry // na ordem do último Put
+	}{
+		{"vazio", nil, nil},
+		{"puts", []op{put("a", "1"), put("b", "2")}, []Entry{{"a", []byte("1")}, {"b", []byte("2")}}},
+		{"checkpoint", []op{put("a", "1"), put("b", "2"), checkpoint("a")}, []Entry{{"b", []byte("2")}}},
+		{"put substitui e vai para o fim", []op{put("a", "1"), put("b", "2"), put("a", "3")}, []Entry{{"b", []byte("2")}, {"a", []byte("3")}}},
+		{"put depois do checkpoint", []op{put("a", "1"), checkpoint("a"), put("a", "2")}, []Entry{{"a", []byte("2")}}},
+		{"checkpoint sem entrada", []op{checkpoint("x"), put("a", "1"), checkpoint("x")}, []Entry{{"a", []byte("1")}}},
+		{"dados vazios", []op{put("a", "")}, []Entry{{"a", []byte{}}}},
+		{"reset", []op{put("a", "1"), {}, put("b", "2")}, []Entry{{"b", []byte("2")}}},
+		{"tudo com checkpoint", []op{put("a", "1"), put("b", "2"), checkpoint("b"), checkpoint("a")}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l, _, _, err := Open(dir, testOptions)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			apply(t, l, tt.ops)
+			if l.Live() != len(tt.want) {
+				t.Fatalf("Live = %d, esperado %d", l.Live(), len(tt.want))
+			}
+			l.Close()
+
+			l, got, rec, err := Open(dir, testOptions)
+			if err != nil {
+				t.Fatalf("reabrindo: %v", err)
+			}
+			defer l.Close()
+			if len(got) == 0 {
+				got = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("entradas = %q, esperado %q", got, tt.want)
+			}
+			if rec.Entries != len(tt.want) || rec.TruncatedBytes != 0 {
+				t.Fatalf("recovery = %+v, esperado %d entradas e nada truncado", rec, len(tt.want))
+			}
+			if l.Live() != len(tt.want) {
+				t.Fatalf("Live depois de reabrir = %d, esperado %d", l.Live(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestOpenTruncatesTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail func(data []byte) []byte
+	}{
+		{"registro pela metade", func(data []byte) []byte {
+			return append(data, segment.AppendRecord(nil, []byte("Pxxxxxxxx"))[:6]...)
+		}},
+		{"checksum errado", func(data []byte) []byte {
+			rec := segment.AppendRecord(nil, appendRecord(nil, opPut, "c", []byte("3"))[segment.HeaderSize:])
+			rec[len(rec)-1] ^= 0xff
+			return append(data, rec...)
+		}},
+		// enquadramento válido mas conteúdo que não é uma operação
+		{"operação inválida", func(data []byte) []byte {
+			return append(data, segment.AppendRecord(nil, []byte("Z\x01c"))...)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l, _, _, err := Open(dir, testOptions)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			apply(t, l, []op{put("a", "1"), put("b", "2")})
+			l.Close()
+
+			files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+			last := files[len(files)-1]
+			data, err := os.ReadFile(last)
+			if err != nil {
+				t.Fatal(err)
+			}
+			corrupted := tt.tail(data)
+			if err := os.WriteFile(last, corrupted, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			l, got, rec, err := Open(dir, testOptions)
+			if err != nil {
+				t.Fatalf("reabrindo: %v", err)
+			}
+			want := []Entry{{"a", []byte("1")}, {"b", []byte("2")}}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("entradas = %q, esperado %q", got, want)
+			}
+			if truncated := int64(len(corrupted) - len(data)); rec.TruncatedBytes != truncated {
+				t.Fatalf("truncados = %d, esperado %d", rec.TruncatedBytes, truncated)
+			}
+
+			// o log continua utilizável depois da recuperação
+			apply(t, l, []op{put("c", "3"), checkpoint("a")})
+			l.Close()
+			_, got, _, err = Open(dir, testOptions)
+			if err != nil {
+				t.Fatalf("reabrindo de novo: %v", err)
+			}
+			want = []Entry{{"b", []byte("2")}, {"c", []byte("3")}}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("entradas = %q, esperado %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	// segmentos pequenos: cada Put abre um segmento novo
+	opts := segment.Options{SegmentBytes: 64, Fsync: segment.FsyncNever}
+	dir := t.TempDir()
+	l, _, _, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// uma entrada que nunca termina seguraria todos os segmentos sem a compactação
+	apply(t, l, []op{put("lenta", "dados da entrada lenta")})
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%d", i)
+		apply(t, l, []op{put(key, "0123456789012345678901234567890123456789"), checkpoint(key)})
+		if n := len(l.segs.Segments()); n > compactSegments+1 {
+			t.Fatalf("depois de %d puts: %d segmentos, esperado no máximo %d", i+1, n, compactSegments+1)
+		}
+	}
+	apply(t, l, []op{put("rápida", "x")})
+	l.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(files) > compactSegments+1 {
+		t.Fatalf("%d segmentos em disco, esperado no máximo %d", len(files), compactSegments+1)
+	}
+	_, got, _, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("reabrindo: %v", err)
+	}
+	want := []Entry{{"lenta", []byte("dados da entrada lenta")}, {"rápida", []byte("x")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("entradas = %q, esperado %q", got, want)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	l, _, _, err := Open(t.TempDir(), testOptions)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	l.Close()
+	if err := l.Put("a", nil); err != ErrClosed {
+		t.Fatalf("Put depois de Close = %v, esperado ErrClosed", err)
+	}
+	if err := l.Checkpoint("a"); err != ErrClosed {
+		t.Fatalf("Checkpoint depois de Close = %v, esperado ErrClosed", err)
+	}
+}
@@ -0,0 +1,55 @@
+package wire
+
+import "slices"
+
+// Versões do protocolo api ↔ worker (ids, acks, ações e seus campos), independente da versão
+// do frame binário. Uma mudança incompatível sobe ProtocolVersion; MinProtocolVersion é a mais
+// antiga que este código ainda entende.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// Features opcionais anunciadas no Hello
+const (
+	FeatureWait   = "wait"   // insert com Wait recebe o resultado final do pagamento
+	FeatureSeries = "series" // get com Interval responde a série de buckets
+	FeatureReplay = "replay" // retransmissões de inserts são respondidas com o ack original
+)
+
+// Hello é a resposta do worker à action "hello": o que ele entende e seus limites.
+// Vai sempre em JSON, que qualquer versão do worker decodifica.
+type Hello struct {
+	Protocol      int      `json:"protocol"`
+	MinProtocol   int      `json:"minProtocol"`
+	WireVersions  []int    `json:"wireVersions"` // versões do frame binário aceitas; vazio: só JSON
+	Build         string   `json:"build"`
+	Actions       []string `json:"actions"`
+	Features      []string `json:"features"`
+	MaxPacketSize int      `json:"maxPacketSize"`
+	Transport     string   `json:"transport"`
+}
+
+// Compatible diz se quem fala ProtocolVersion pode conversar com o dono de h
+func (h *Hello) Compatible() bool {
+	return h.Protocol >= MinProtocolVersion && ProtocolVersion >= h.MinProtocol
+}
+
+func (h *Hello) SupportsAction(action string) bool {
+	return slices.Contains(h.Actions, action)
+}
+
+func (h *Hello) SupportsFeature(feature string) bool {
+	return slices.Contains(h.Features, feature)
+}
+
+// SupportsBinary diz se o worker decodifica frames binários desta versão
+func (h *Hello) SupportsBinary() bool {
+	return slices.Contains(h.WireVersions, int(Version))
+}
+
+// ErrorReply é a resposta do worker a uma requisição que ele não sabe tratar
+type ErrorReply struct {
+	Error  string `json:"error"`
+	Action string `json:"action,omitempty"`
+}
@@ -0,0 +1,356 @@
+// Package wire define as mensagens trocadas entre api e worker e a codificação binária delas.
+//
+// Um frame binário começa com um cabeçalho fixo:
+//
+//	byte 0     Magic (0xB7, nunca é o início de um JSON)
+//	byte 1     versão do formato
+//	byte 2     tipo do frame (KindRequest, KindSummary)
+//	bytes 3-6  tamanho do corpo, uint32 big-endian
+//
+// O corpo usa varints e strings prefixadas pelo tamanho, na ordem dos campos de cada tipo.
+// JSON continua aceito dos dois lados: IsBinary diz qual decodificador usar.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"payment-proxy/internal/payments/entities"
+	"time"
+)
+
+const (
+	Magic   byte = 0xB7
+	Version byte = 1
+
+	headerSize = 7
+
+	// MaxReplySize é a maior resposta do worker; com o envelope do ipcauth ainda cabe num datagrama UDP
+	MaxReplySize = 65000
+)
+
+// Kind identifica o conteúdo de um frame binário
+type Kind byte
+
+const (
+	KindRequest Kind = 1
+	KindSummary Kind = 2
+)
+
+var (
+	ErrMalformed          = errors.New("wire: malformed frame")
+	ErrUnsupportedVersion = errors.New("wire: unsupported version")
+	ErrUnexpectedKind     = errors.New("wire: unexpected frame kind")
+)
+
+// BatchItem é um pagamento dentro de um "insert_batch"
+type BatchItem struct {
+	Key     string           `json:"key,omitempty"`
+	Payment entities.Payment `json:"payment"`
+}
+
+// Request é toda mensagem da api para o worker. As tags json mantêm o formato antigo,
+// que o worker ainda aceita durante uma atualização.
+type Request struct {
+	ID            uint64           `json:"id"`            // ecoado em toda resposta; permite ao servidor casar resposta e requisição
+	Sender        string           `json:"sender"`        // instância do servidor; com ID identifica retransmissões de inserts
+	Action        string           `json:"action"`        // "insert", "insert_batch", "get", "status", "purge" ou "hello"
+	Key           string           `json:"key"`           // Idempotency-Key opcional, usado apenas se Action == "insert"
+	Wait          int64            `json:"wait"`          // ms que o servidor aguarda o resultado final, usado apenas se Action == "insert"
+	Payment       entities.Payment `json:"payment"`       // usado apenas se Action == "insert"
+	From          *time.Time       `json:"from"`          // usado apenas se Action == "get"
+	To            *time.Time       `json:"to"`            // usado apenas se Action == "get"
+	Interval      int64            `json:"interval"`      // ms de cada bucket; se > 0 o "get" retorna uma série
+	CorrelationID string           `json:"correlationId"` // usado apenas se Action == "status"
+	Items         []BatchItem      `json:"items"`         // usado apenas se Action == "insert_batch"
+}
+
+// IsBinary diz se data é um frame binário; caso contrário deve ser JSON
+func IsBinary(data []byte) bool {
+	return len(data) > 0 && data[0] == Magic
+}
+
+// actions comuns viram um byte; qualquer outra vai como string depois do código 0
+var actionCodes = map[string]byte{
+	"insert":       1,
+	"insert_batch": 2,
+	"get":          3,
+	"status":       4,
+	"purge":        5,
+	"hello":        6,
+}
+
+var actionNames = func() map[byte]string {
+	m := make(map[byte]string, len(actionCodes))
+	for name, code := range actionCodes {
+		m[code] = name
+	}
+	return m
+}()
+
+// AppendRequest acrescenta r codificado em binário a dst
+func AppendRequest(dst []byte, r *Request) []byte {
+	dst, start := beginFrame(dst, KindRequest)
+	dst = binary.AppendUvarint(dst, r.ID)
+	dst = appendString(dst, r.Sender)
+	if code, ok := actionCodes[r.Action]; ok {
+		dst = append(dst, code)
+	} else {
+		dst = append(dst, 0)
+		dst = appendString(dst, r.Action)
+	}
+	dst = appendString(dst, r.Key)
+	dst = binary.AppendVarint(dst, r.Wait)
+	dst = appendPayment(dst, &r.Payment)
+	dst = appendTimePtr(dst, r.From)
+	dst = appendTimePtr(dst, r.To)
+	dst = binary.AppendVarint(dst, r.Interval)
+	dst = appendString(dst, r.CorrelationID)
+	dst = binary.AppendUvarint(dst, uint64(len(r.Items)))
+	for i := range r.Items {
+		dst = appendString(dst, r.Items[i].Key)
+		dst = appendPayment(dst, &r.Items[i].Payment)
+	}
+	return endFrame(dst, start)
+}
+
+// BatchItemSize é quanto um item ocupa num frame binário, para empacotar lotes sem codificar duas vezes
+func BatchItemSize(item *BatchItem) int {
+	return len(appendPayment(appendString(nil, item.Key), &item.Payment))
+}
+
+// DecodeRequest decodifica um frame binário em r
+func DecodeRequest(data []byte, r *Request) error {
+	d, err := openFrame(data, KindRequest)
+	if err != nil {
+		return err
+	}
+	*r = Request{}
+	r.ID = d.uvarint()
+	r.Sender = d.string()
+	if code := d.byte(); code != 0 {
+		r.Action = actionNames[code]
+	} else {
+		r.Action = d.string()
+	}
+	r.Key = d.string()
+	r.Wait = d.varint()
+	d.payment(&r.Payment)
+	r.From = d.timePtr()
+	r.To = d.timePtr()
+	r.Interval = d.varint()
+	r.CorrelationID = d.string()
+	if n := d.uvarint(); n > 0 {
+		// cada item ocupa pelo menos alguns bytes: evita alocar um slice enorme a partir de um tamanho forjado
+		if n > uint64(len(d.buf)) {
+			return ErrMalformed
+		}
+		r.Items = make([]BatchItem, n)
+		for i := range r.Items {
+			r.Items[i].Key = d.string()
+			d.payment(&r.Items[i].Payment)
+		}
+	}
+	return d.finish()
+}
+
+// AppendSummary codifica a resposta de um "get" sem interval para a requisição id
+func AppendSummary(dst []byte, id uint64, s *entities.AggregatedSummary) []byte {
+	dst, start := beginFrame(dst, KindSummary)
+	dst = binary.AppendUvarint(dst, id)
+	dst = appendSummary(dst, &s.Default)
+	dst = appendSummary(dst, &s.Fallback)
+	return endFrame(dst, start)
+}
+
+// DecodeSummary decodifica um frame KindSummary e retorna o id da requisição
+func DecodeSummary(data []byte, s *entities.AggregatedSummary) (uint64, error) {
+	d, err := openFrame(data, KindSummary)
+	if err != nil {
+		return 0, err
+	}
+	id := d.uvarint()
+	d.summary(&s.Default)
+	d.summary(&s.Fallback)
+	return id, d.finish()
+}
+
+// ReplyID lê o id de um frame de resposta sem decodificar o resto
+func ReplyID(data []byte) (uint64, error) {
+	d, err := openFrame(data, KindSummary)
+	if err != nil {
+		return 0, err
+	}
+	id := d.uvarint()
+	if d.err {
+		return 0, ErrMalformed
+	}
+	return id, nil
+}
+
+// ----------- codificação -----------
+
+func beginFrame(dst []byte, kind Kind) ([]byte, int) {
+	start := len(dst)
+	dst = append(dst, Magic, Version, byte(kind), 0, 0, 0, 0)
+	return dst, start
+}
+
+func endFrame(dst []byte, start int) []byte {
+	binary.BigEndian.PutUint32(dst[start+3:], uint32(len(dst)-start-headerSize))
+	return dst
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func appendFloat(dst []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(dst, math.Float64bits(f))
+}
+
+// appendTime usa um byte de presença para distinguir o tempo zero de 1970-01-01
+func appendTime(dst []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return append(dst, 0)
+	}
+	dst = append(dst, 1)
+	return binary.AppendVarint(dst, t.UnixNano())
+}
+
+// appendTimePtr: nil e ponteiro para o tempo zero viram o mesmo byte e voltam como nil
+func appendTimePtr(dst []byte, t *time.Time) []byte {
+	if t == nil {
+		return append(dst, 0)
+	}
+	return appendTime(dst, *t)
+}
+
+func appendPayment(dst []byte, p *entities.Payment) []byte {
+	dst = appendString(dst, p.CorrelationID)
+	dst = appendFloat(dst, p.Amount)
+	dst = appendTime(dst, p.RequestedAt)
+	return binary.AppendVarint(dst, int64(p.PaymentGatewayType))
+}
+
+func appendSummary(dst []byte, s *entities.Summary) []byte {
+	dst = binary.AppendVarint(dst, int64(s.TotalRequests))
+	return appendFloat(dst, s.TotalAmount)
+}
+
+// ----------- decodificação -----------
+
+// decoder lê o corpo de um frame; o primeiro erro marca err e as leituras seguintes viram zero
+type decoder struct {
+	buf []byte
+	err bool
+}
+
+func openFrame(data []byte, kind Kind) (*decoder, error) {
+	if len(data) < headerSize || data[0] != Magic {
+		return nil, ErrMalformed
+	}
+	if data[1] != Version {
+		return nil, ErrUnsupportedVersion
+	}
+	if Kind(data[2]) != kind {
+		return nil, ErrUnexpectedKind
+	}
+	if n := binary.BigEndian.Uint32(data[3:]); int(n) != len(data)-headerSize {
+		return nil, ErrMalformed
+	}
+	return &decoder{buf: data[headerSize:]}, nil
+}
+
+func (d *decoder) finish() error {
+	if d.err || len(d.buf) != 0 {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func (d *decoder) byte() byte {
+	if d.err || len(d.buf) < 1 {
+		d.err = true
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = true
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = true
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err || n > uint64(len(d.buf)) {
+		d.err = true
+		return ""
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *decoder) float() float64 {
+	if d.err || len(d.buf) < 8 {
+		d.err = true
+		return 0
+	}
+	f := math.Float64frombits(binary.BigEndian.Uint64(d.buf))
+	d.buf = d.buf[8:]
+	return f
+}
+
+func (d *decoder) time() time.Time {
+	if d.byte() == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, d.varint()).UTC()
+}
+
+func (d *decoder) timePtr() *time.Time {
+	if len(d.buf) > 0 && d.buf[0] == 0 {
+		d.buf = d.buf[1:]
+		return nil
+	}
+	t := d.time()
+	return &t
+}
+
+func (d *decoder) payment(p *entities.Payment) {
+	p.CorrelationID = d.string()
+	p.Amount = d.float()
+	p.RequestedAt = d.time()
+	p.PaymentGatewayType = entities.GatewayType(d.varint())
+}
+
+func (d *decoder) summary(s *entities.Summary) {
+	s.TotalRequests = int(d.varint())
+	s.TotalAmount = d.float()
+}
@@ -0,0 +1,293 @@
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"payment-proxy/internal/payments/entities"
+	"reflect"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigFastest
+
+var (
+	testTime    = time.Date(2025, 7, 14, 12, 30, 15, 123456789, time.UTC)
+	testPayment = entities.Payment{
+		CorrelationID: "4a7901b8-7d26-4d9d-aa19-4dc1c7cf60b3",
+		Amount:        19.90,
+		RequestedAt:   testTime,
+	}
+)
+
+func testBatch(n int) *Request {
+	r := &Request{ID: 123457, Sender: "9f86d081884c7d65", Action: "insert_batch"}
+	for i := 0; i < n; i++ {
+		r.Items = append(r.Items, BatchItem{Payment: testPayment})
+	}
+	return r
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	from, to := testTime.Add(-time.Hour), testTime
+	tests := []struct {
+		name string
+		req  Request
+	}{
+		{"insert", Request{ID: 1, Sender: "9f86d081884c7d65", Action: "insert", Key: "key-1", Wait: 5000, Payment: testPayment}},
+		{"insert sem horário", Request{ID: 2, Action: "insert", Payment: entities.Payment{CorrelationID: "a", Amount: 0.01}}},
+		{"insert_batch", Request{ID: 3, Sender: "s", Action: "insert_batch", Items: []BatchItem{
+			{Key: "k1", Payment: testPayment},
+			{Payment: entities.Payment{CorrelationID: "b", Amount: 1, PaymentGatewayType: entities.GatewayType(1)}},
+		}}},
+		{"get", Request{ID: 4, Action: "get", From: &from, To: &to, Interval: 1000}},
+		{"get sem período", Request{ID: 5, Action: "get"}},
+		{"status", Request{ID: 6, Action: "status", CorrelationID: "c"}},
+		{"hello", Request{ID: 7, Action: "hello"}},
+		// action sem código vai como string
+		{"dlq_list", Request{ID: 1 << 40, Action: "dlq_list", From: &from}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := AppendRequest(nil, &tt.req)
+			if !IsBinary(data) {
+				t.Fatalf("frame sem o byte mágico: %x", data[0])
+			}
+			var got Request
+			if err := DecodeRequest(data, &got); err != nil {
+				t.Fatalf("DecodeRequest: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.req) {
+				t.Fatalf("got %+v, want %+v", got, tt.req)
+			}
+		})
+	}
+}
+
+func TestAppendRequestKeepsPrefix(t *testing.T) {
+	req := Request{ID: 9, Action: "insert", Payment: testPayment}
+	prefix := []byte("abc")
+	data := AppendRequest(append([]byte(nil), prefix...), &req)
+	if string(data[:3]) != "abc" {
+		t.Fatalf("prefixo alterado: %q", data[:3])
+	}
+	var got Request
+	if err := DecodeRequest(data[3:], &got); err != nil || !reflect.DeepEqual(got, req) {
+		t.Fatalf("got %+v (%v), want %+v", got, err, req)
+	}
+}
+
+func TestBatchItemSize(t *testing.T) {
+	empty := len(AppendRequest(nil, testBatch(0)))
+	item := BatchItem{Key: "key-1", Payment: testPayment}
+	req := testBatch(0)
+	req.Items = []BatchItem{item}
+	if got, want := len(AppendRequest(nil, req))-empty, BatchItemSize(&item); got != want {
+		t.Fatalf("item ocupa %d bytes no frame, BatchItemSize = %d", got, want)
+	}
+}
+
+func TestSummaryRoundTrip(t *testing.T) {
+	want := entities.AggregatedSummary{
+		Default:  entities.Summary{TotalRequests: 15234, TotalAmount: 303156.6},
+		Fallback: entities.Summary{TotalRequests: 812, TotalAmount: 16158.8},
+	}
+	data := AppendSummary(nil, 42, &want)
+	var got entities.AggregatedSummary
+	id, err := DecodeSummary(data, &got)
+	if err != nil || id != 42 || got != want {
+		t.Fatalf("got %+v id=%d (%v), want %+v id=42", got, id, err, want)
+	}
+	if id, err := ReplyID(data); err != nil || id != 42 {
+		t.Fatalf("ReplyID = %d (%v), want 42", id, err)
+	}
+}
+
+// withLength corrige o tamanho do corpo no cabeçalho, para que o erro venha do corpo
+func withLength(frame []byte) []byte {
+	out := append([]byte(nil), frame...)
+	binary.BigEndian.PutUint32(out[3:], uint32(len(out)-headerSize))
+	return out
+}
+
+func TestDecodeRequestTruncated(t *testing.T) {
+	req := testBatch(3)
+	req.Key = "key"
+	from := testTime
+	req.From = &from
+	data := AppendRequest(nil, req)
+	for n := 0; n < len(data); n++ {
+		var got Request
+		if err := DecodeRequest(data[:n], &got); err == nil {
+			t.Fatalf("frame truncado em %d de %d bytes foi aceito", n, len(data))
+		}
+		if n < headerSize {
+			continue
+		}
+		// cabeçalho coerente, corpo incompleto
+		if err := DecodeRequest(withLength(data[:n]), &got); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("corpo truncado em %d de %d bytes: err = %v, want ErrMalformed", n, len(data), err)
+		}
+	}
+}
+
+func TestDecodeRequestMalformed(t *testing.T) {
+	valid := AppendRequest(nil, &Request{ID: 1, Action: "insert", Payment: testPayment})
+	modified := func(fn func(b []byte) []byte) []byte {
+		return fn(append([]byte(nil), valid...))
+	}
+	// frame com um insert_batch que declara count itens e não traz nenhum
+	forgedItems := func(count uint64) []byte {
+		b := AppendRequest(nil, &Request{ID: 1, Action: "insert_batch"})
+		b = binary.AppendUvarint(b[:len(b)-1], count)
+		return withLength(b)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"vazio", nil, ErrMalformed},
+		{"json", []byte(`{"id":1,"action":"insert"}`), ErrMalformed},
+		{"versão", modified(func(b []byte) []byte { b[1] = Version + 1; return b }), ErrUnsupportedVersion},
+		{"tipo", modified(func(b []byte) []byte { b[2] = byte(KindSummary); return b }), ErrUnexpectedKind},
+		{"tamanho maior que o frame", modified(func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[3:], uint32(len(b)))
+			return b
+		}), ErrMalformed},
+		{"bytes sobrando", withLength(append(append([]byte(nil), valid...), 0)), ErrMalformed},
+		{"string além do fim", modified(func(b []byte) []byte {
+			// o sender (depois do id, um varint de 1 byte) declara 100 bytes
+			b[headerSize+1] = 100
+			return b
+		}), ErrMalformed},
+		{"varint sem fim", withLength(append(valid[:headerSize:headerSize], 0xff, 0xff, 0xff)), ErrMalformed},
+		{"itens forjados", forgedItems(1 << 40), ErrMalformed},
+		{"itens faltando", forgedItems(2), ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Request
+			if err := DecodeRequest(tt.data, &got); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSummaryMalformed(t *testing.T) {
+	data := AppendSummary(nil, 1, &entities.AggregatedSummary{})
+	for n := headerSize; n < len(data); n++ {
+		var s entities.AggregatedSummary
+		if _, err := DecodeSummary(withLength(data[:n]), &s); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("resumo truncado em %d de %d bytes: err = %v", n, len(data), err)
+		}
+	}
+	if _, err := ReplyID(withLength(data[:headerSize])); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("ReplyID sem corpo: err = %v", err)
+	}
+}
+
+// Os benchmarks comparam o JSON (jsoniter, como antes do internal/wire) com o binário;
+// bytes/msg é o tamanho da mensagem em cada formato.
+//
+//	go test -run '^$' -bench . ./internal/wire
+
+func bench(b *testing.B, size int, fn func()) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fn()
+	}
+	b.ReportMetric(float64(size), "bytes/msg")
+}
+
+func BenchmarkInsert(b *testing.B) {
+	insert := &Request{ID: 123456, Sender: "9f86d081884c7d65", Action: "insert", Payment: testPayment}
+	insertJSON, _ := json.Marshal(insert)
+	insertBin := AppendRequest(nil, insert)
+
+	b.Run("encode/json-map", func(b *testing.B) {
+		// caminho antigo do servidor: map[string]interface{} por requisição
+		bench(b, len(insertJSON), func() {
+			json.Marshal(map[string]interface{}{
+				"action": "insert", "id": insert.ID, "sender": insert.Sender, "payment": insert.Payment,
+			})
+		})
+	})
+	b.Run("encode/json", func(b *testing.B) {
+		bench(b, len(insertJSON), func() { json.Marshal(insert) })
+	})
+	b.Run("encode/binary", func(b *testing.B) {
+		buf := make([]byte, 0, 512)
+		bench(b, len(insertBin), func() { buf = AppendRequest(buf[:0], insert) })
+	})
+	b.Run("decode/json", func(b *testing.B) {
+		bench(b, len(insertJSON), func() {
+			var r Request
+			json.Unmarshal(insertJSON, &r)
+		})
+	})
+	b.Run("decode/binary", func(b *testing.B) {
+		bench(b, len(insertBin), func() {
+			var r Request
+			DecodeRequest(insertBin, &r)
+		})
+	})
+}
+
+func BenchmarkBatch50(b *testing.B) {
+	batch := testBatch(50)
+	batchJSON, _ := json.Marshal(batch)
+	batchBin := AppendRequest(nil, batch)
+
+	b.Run("encode/json", func(b *testing.B) {
+		bench(b, len(batchJSON), func() { json.Marshal(batch) })
+	})
+	b.Run("encode/binary", func(b *testing.B) {
+		buf := make([]byte, 0, 8192)
+		bench(b, len(batchBin), func() { buf = AppendRequest(buf[:0], batch) })
+	})
+	b.Run("decode/json", func(b *testing.B) {
+		bench(b, len(batchJSON), func() {
+			var r Request
+			json.Unmarshal(batchJSON, &r)
+		})
+	})
+	b.Run("decode/binary", func(b *testing.B) {
+		bench(b, len(batchBin), func() {
+			var r Request
+			DecodeRequest(batchBin, &r)
+		})
+	})
+}
+
+func BenchmarkSummary(b *testing.B) {
+	summary := entities.AggregatedSummary{
+		Default:  entities.Summary{TotalRequests: 15234, TotalAmount: 303156.6},
+		Fallback: entities.Summary{TotalRequests: 812, TotalAmount: 16158.8},
+	}
+	summaryJSON, _ := json.Marshal(summary)
+	summaryBin := AppendSummary(nil, 1, &summary)
+
+	b.Run("encode/json", func(b *testing.B) {
+		bench(b, len(summaryJSON), func() { json.Marshal(summary) })
+	})
+	b.Run("encode/binary", func(b *testing.B) {
+		buf := make([]byte, 0, 64)
+		bench(b, len(summaryBin), func() { buf = AppendSummary(buf[:0], 1, &summary) })
+	})
+	b.Run("decode/json", func(b *testing.B) {
+		bench(b, len(summaryJSON), func() {
+			var s entities.AggregatedSummary
+			json.Unmarshal(summaryJSON, &s)
+		})
+	})
+	b.Run("decode/binary", func(b *testing.B) {
+		bench(b, len(summaryBin), func() {
+			var s entities.AggregatedSummary
+			DecodeSummary(summaryBin, &s)
+		})
+	})
+}